@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+// readOnlyCircleCI hands out a CircleCI client that print what commands would change rather than changing it
+type readOnlyCircleCI struct {
+	base stepfunc.CircleCIClients
+	log  io.Writer
+}
+
+func (r readOnlyCircleCI) Client(ctx context.Context, c stepfunc.Config, logger *slog.Logger) circleci.API {
+	return readOnly{API: r.base.Client(ctx, c, logger), log: r.log}
+}
+
+// readOnly is a CircleCI client that only reads
+type readOnly struct {
+	circleci.API
+	log io.Writer
+}
+
+func (r readOnly) WithContext(ctx context.Context) circleci.API {
+	return readOnly{API: r.API.WithContext(ctx), log: r.log}
+}
+
+func (r readOnly) RerunWorkflow(workflowID string, opts circleci.RerunWorkflowOptions) (*circleci.RerunWorkflowResponse, error) {
+	fmt.Fprintf(r.log, "Would rerun workflow %s with %+v\n", workflowID, opts)
+	return &circleci.RerunWorkflowResponse{WorkflowID: workflowID + "-rerun"}, nil
+}
+
+func (r readOnly) CancelWorkflow(workflowID string) error {
+	fmt.Fprintf(r.log, "Would cancel workflow %s\n", workflowID)
+	return nil
+}
+
+func (r readOnly) CancelJob(vcsProvider, account, repo string, jobNumber int) error {
+	fmt.Fprintf(r.log, "Would cancel job %d of %s\n", jobNumber, circleci.ProjectSlug(vcsProvider, account, repo))
+	return nil
+}
+
+func (r readOnly) ApproveJob(workflowID, approvalRequestID string) error {
+	fmt.Fprintf(r.log, "Would approve %s in workflow %s\n", approvalRequestID, workflowID)
+	return nil
+}
+
+func (r readOnly) TriggerPipeline(vcsProvider, account, repo string, opts circleci.TriggerPipelineOptions) (*circleci.PipelineCreated, error) {
+	return nil, fmt.Errorf("Triggering pipelines is turned off, replay with -act")
+}
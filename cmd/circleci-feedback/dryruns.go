@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/codingdiaz/circleci-feedback/internal/dryrun"
+)
+
+// dryRuns prints the comments kept for the installations and repos in dry run, newest first
+func dryRuns(args []string) error {
+	flags := flag.NewFlagSet("dry-runs", flag.ContinueOnError)
+	repo := flags.String("repo", "", "only the comments on this repo, as owner/repo")
+	limit := flags.Int("limit", 20, "how many comments to print")
+	kind := flags.String("store", os.Getenv("DRYRUN_STORE"), "the dry run store the functions use, file or dynamodb")
+	location := flags.String("location", os.Getenv("DRYRUN_LOCATION"), "the file path or table name of the store")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: circleci-feedback dry-runs [flags]")
+		fmt.Fprintln(flags.Output(), "The store defaults to DRYRUN_STORE and DRYRUN_LOCATION, like the functions")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || *limit < 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	// the memory store of the functions isn't reachable from here, an empty one would just print nothing
+	if *kind == "" || *kind == "memory" {
+		return fmt.Errorf("Set -store, or DRYRUN_STORE, to the file or dynamodb store of the functions")
+	}
+
+	store, err := dryrun.NewStore(*kind, *location)
+	if err != nil {
+		return err
+	}
+
+	comments, err := store.Recent(*repo, *limit)
+	if err != nil {
+		return fmt.Errorf("Error listing the dry run comments, error: %s", err)
+	}
+
+	if len(comments) == 0 {
+		fmt.Fprintln(os.Stderr, "No dry run comments")
+		return nil
+	}
+	for _, c := range comments {
+		printDryRun(c)
+	}
+
+	return nil
+}
+
+func printDryRun(c dryrun.Comment) {
+	where := fmt.Sprintf("%s#%d", c.Repo, c.Number)
+	if c.EditOf != 0 {
+		where = fmt.Sprintf("%s comment %d", c.Repo, c.EditOf)
+	}
+
+	fmt.Fprintf(os.Stdout, "=== %s, dry run comment %d, created %s", where, c.ID, c.CreatedAt.Local().Format(time.RFC3339))
+	if c.Edits > 0 {
+		fmt.Fprintf(os.Stdout, ", edited %d times, last %s", c.Edits, c.UpdatedAt.Local().Format(time.RFC3339))
+	}
+	fmt.Fprintf(os.Stdout, "\n%s\n\n", c.Body)
+}
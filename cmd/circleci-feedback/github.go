@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+	"github.com/google/go-github/github"
+)
+
+// printer is the GitHub of an installation that prints comments rather than posting them
+// Reads go to GitHub, or find nothing when there is no GitHub to read from
+type printer struct {
+	githubapp.API           // nil reads nothing
+	out           io.Writer // gets the comment bodies
+	log           io.Writer // gets where each comment would go
+
+	mu     sync.Mutex
+	nextID int64
+	bodies map[int64]string
+}
+
+// printers hands out printers for every installation
+type printers struct {
+	base githubapp.Installations // nil when GitHub isn't read
+	out  io.Writer
+	log  io.Writer
+
+	mu       sync.Mutex
+	printers map[int64]*printer
+}
+
+func (p *printers) Installation(installationID int64) (githubapp.API, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pr, ok := p.printers[installationID]; ok {
+		return pr, nil
+	}
+
+	pr := &printer{out: p.out, log: p.log, bodies: map[int64]string{}}
+	if p.base != nil {
+		api, err := p.base.Installation(installationID)
+		if err != nil {
+			return nil, err
+		}
+		pr.API = api
+	}
+
+	if p.printers == nil {
+		p.printers = map[int64]*printer{}
+	}
+	p.printers[installationID] = pr
+	return pr, nil
+}
+
+// printed says whether any printer printed a comment
+func (p *printers) printed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, pr := range p.printers {
+		if pr.nextID > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) CreateComment(ctx context.Context, owner, repo string, number int, body string) (*github.IssueComment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextID++
+	id := p.nextID
+	p.bodies[id] = body
+
+	fmt.Fprintf(p.log, "Would comment on %s/%s#%d:\n", owner, repo, number)
+	fmt.Fprintln(p.out, body)
+	return &github.IssueComment{ID: &id, Body: &body}, nil
+}
+
+func (p *printer) GetComment(ctx context.Context, owner, repo string, id int64) (*github.IssueComment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// only the comments that were printed exist
+	body, ok := p.bodies[id]
+	if !ok {
+		return nil, githubapp.ErrNotFound
+	}
+	return &github.IssueComment{ID: &id, Body: &body}, nil
+}
+
+func (p *printer) EditComment(ctx context.Context, owner, repo string, id int64, body string) (*github.IssueComment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.bodies[id]; !ok {
+		return nil, githubapp.ErrNotFound
+	}
+	p.bodies[id] = body
+
+	fmt.Fprintf(p.log, "Would edit comment %d on %s/%s:\n", id, owner, repo)
+	fmt.Fprintln(p.out, body)
+	return &github.IssueComment{ID: &id, Body: &body}, nil
+}
+
+func (p *printer) GetFile(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	if p.API == nil {
+		return nil, githubapp.ErrNotFound
+	}
+	return p.API.GetFile(ctx, owner, repo, path, ref)
+}
+
+func (p *printer) GetPermissionLevel(ctx context.Context, owner, repo, login string) (string, error) {
+	if p.API == nil {
+		return "none", nil
+	}
+	return p.API.GetPermissionLevel(ctx, owner, repo, login)
+}
+
+func (p *printer) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, error) {
+	if p.API == nil {
+		return nil, githubapp.ErrNotFound
+	}
+	return p.API.GetPullRequest(ctx, owner, repo, number)
+}
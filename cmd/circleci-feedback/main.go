@@ -0,0 +1,79 @@
+// Command circleci-feedback runs the logic of the functions locally, to debug them without deploying
+//
+//	circleci-feedback replay [flags] <payload.json>     handle a saved webhook like the entry function
+//	circleci-feedback report -repo owner/repo -sha SHA  print the report of the pipeline of a commit
+//	circleci-feedback validate-config <file.json>       check a JSON repo config document
+//	circleci-feedback dry-runs [-repo owner/repo]       print the comments kept for repos in dry run
+//
+// The configuration comes from the environment, see stepfunc.EnvConfig, or from SSM with -ssm
+// Nothing is written to GitHub or started in Step Functions unless a flag asks for it
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/codingdiaz/circleci-feedback/internal/logging"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+)
+
+const usage = `Usage: circleci-feedback <command> [flags]
+
+Commands:
+  replay <payload.json>           handle a saved GitHub webhook like the entry function
+  report -repo owner/repo -sha X  print the report comment of the pipeline of a commit
+  validate-config <file.json>     check a JSON repo config document
+  dry-runs [-repo owner/repo]     print the comments kept for repos in dry run
+
+Run circleci-feedback <command> -h for the flags of a command.
+`
+
+func main() {
+	err := logging.SetupCLI()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func(args []string) error{
+		"replay":          replay,
+		"report":          report,
+		"validate-config": validateConfig,
+		"dry-runs":        dryRuns,
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	err = command(os.Args[2:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// loadConfig reads the configuration once, from the environment unless fromSSM is set
+func loadConfig(fromSSM bool) (stepfunc.StaticConfig, error) {
+	var source stepfunc.ConfigSource = stepfunc.EnvConfig{}
+	if fromSSM {
+		source = stepfunc.SSMConfig{}
+	}
+
+	c, err := source.Config()
+	if err != nil {
+		return stepfunc.StaticConfig{}, fmt.Errorf("Error reading the configuration, error: %s", err)
+	}
+
+	return stepfunc.StaticConfig(c), nil
+}
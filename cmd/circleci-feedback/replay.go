@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/codingdiaz/circleci-feedback/internal/dedup"
+	"github.com/codingdiaz/circleci-feedback/internal/entry"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+)
+
+// replay handles a saved webhook with the entry function handler
+// The file is either the API Gateway request, as logged by a deployment, or the payload GitHub sent, which
+// is signed with the configured webhook secret. Comments are printed, CircleCI is only read and executions aren't started
+// unless -post, -act and -start are set
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	event := flags.String("event", "", "the X-GitHub-Event of a payload, like pull_request or issue_comment")
+	delivery := flags.String("delivery", "", "the X-GitHub-Delivery of a payload, defaults to a new one")
+	post := flags.Bool("post", false, "post the comments on GitHub rather than printing them")
+	act := flags.Bool("act", false, "let /circleci commands rerun and cancel workflows on CircleCI rather than printing what they would do")
+	start := flags.Bool("start", false, "start the step function execution of STEP_FUNCTION_ARN rather than printing its input")
+	fromSSM := flags.Bool("ssm", false, "read the configuration from SSM like the functions do")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: circleci-feedback replay [flags] <payload.json>")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	b, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Error reading the webhook, error: %s", err)
+	}
+
+	config, err := loadConfig(*fromSSM)
+	if err != nil {
+		return err
+	}
+
+	// payloads are signed by us, they don't need the real secret
+	if config.GitHubWebhookSecret == "" {
+		config.GitHubWebhookSecret = "replay"
+	}
+
+	request, err := webhookRequest(b, *event, *delivery, config.GitHubWebhookSecret)
+	if err != nil {
+		return err
+	}
+
+	var github githubapp.Installations = &stepfunc.GitHubClients{Config: config}
+	if !*post {
+		github = &printers{base: github, out: os.Stdout, log: os.Stderr}
+	}
+
+	var circle stepfunc.CircleCIClients = &stepfunc.CircleCI{}
+	if !*act {
+		circle = readOnlyCircleCI{base: circle, log: os.Stderr}
+	}
+
+	var executions entry.ExecutionStarter = entry.StepFunctions{StateMachineARN: os.Getenv("STEP_FUNCTION_ARN")}
+	if !*start {
+		executions = printExecutions{}
+	}
+
+	h := &entry.Handler{
+		Deliveries: dedup.NewMemoryStore(),
+		Config:     config,
+		GitHub:     github,
+		CircleCI:   circle,
+		Executions: executions,
+	}
+
+	response, err := h.Handle(context.Background(), request)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "The entry function answered %d\n", response.StatusCode)
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("The webhook was not handled")
+	}
+
+	return nil
+}
+
+// webhookRequest reads a saved API Gateway request, or makes the request GitHub would send for a payload
+func webhookRequest(b []byte, event, delivery, secret string) (events.APIGatewayProxyRequest, error) {
+	saved := events.APIGatewayProxyRequest{}
+	err := json.Unmarshal(b, &saved)
+	if err == nil && saved.Body != "" && len(saved.Headers) > 0 {
+		return saved, nil
+	}
+
+	if event == "" {
+		return saved, fmt.Errorf("The file is a webhook payload, -event has to say which event it is")
+	}
+	if delivery == "" {
+		delivery = "replay-" + strconv.FormatInt(time.Now().Unix(), 10)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(b)
+
+	return events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/entry",
+		Headers: map[string]string{
+			"X-GitHub-Event":      event,
+			"X-GitHub-Delivery":   delivery,
+			"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+			"Content-Type":        "application/json",
+		},
+		Body: string(b),
+	}, nil
+}
+
+// printExecutions prints the input of the executions it is asked to start
+type printExecutions struct{}
+
+func (printExecutions) StartExecution(input stepfunc.Data, name string) error {
+	b, err := json.MarshalIndent(input, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Would start execution %s with:\n", name)
+	fmt.Println(string(b))
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codingdiaz/circleci-feedback/internal/blob"
+	"github.com/codingdiaz/circleci-feedback/internal/findpipeline"
+	"github.com/codingdiaz/circleci-feedback/internal/flaky"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/waitforjobs"
+)
+
+// report finds the pipeline of a commit and prints the report wait_for_jobs would post for it right now
+// Only CircleCI is read, GitHub is left alone so the report has no code owners
+func report(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	repo := flags.String("repo", "", "the repo, as owner/repo")
+	sha := flags.String("sha", "", "the commit the pipeline built")
+	branch := flags.String("branch", "", "the branch of the commit, the pipelines of every branch are searched without it")
+	base := flags.String("base", "", "the base branch of the pull request, failures already on it are marked")
+	author := flags.String("author", "", "the login of the author of the pull request, mentioned in the report")
+	number := flags.Int("pr", 0, "the number of the pull request, for the links of notifications")
+	fromSSM := flags.Bool("ssm", false, "read the configuration from SSM like the functions do")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: circleci-feedback report -repo owner/repo -sha SHA [flags]")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Split(*repo, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || *sha == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	config, err := loadConfig(*fromSSM)
+	if err != nil {
+		return err
+	}
+
+	// the handlers of the step function do the work, once each and without waiting
+	in := stepfunc.Data{
+		CorrelationID:     "report",
+		Owner:             parts[0],
+		RepoName:          parts[1],
+		CommitSHA:         *sha,
+		Branch:            *branch,
+		BaseBranch:        *base,
+		Author:            *author,
+		PullRequestNumber: *number,
+		GitHubHost:        config.GitHubHost,
+	}
+	circle := &stepfunc.CircleCI{}
+
+	find := &findpipeline.Handler{Config: config, CircleCI: circle}
+	in, err = find.Handle(context.Background(), in)
+	if err != nil {
+		return err
+	}
+	if !in.PipelineFound {
+		return fmt.Errorf("CircleCI has no pipeline for %s", *sha)
+	}
+
+	// the report is only printed, nothing is posted or notified
+	config.Repos = nil
+	github := &printers{out: os.Stdout, log: os.Stderr}
+	wait := &waitforjobs.Handler{
+		History:  flaky.NewMemoryStore(),
+		Blobs:    blob.NewMemoryStore(),
+		Config:   config,
+		GitHub:   github,
+		CircleCI: circle,
+	}
+	in, err = wait.Handle(context.Background(), in)
+	if err != nil {
+		return err
+	}
+
+	if !github.printed() {
+		state := "is done"
+		if !in.AllJobsDone {
+			state = "is still running"
+		}
+		fmt.Fprintf(os.Stderr, "Pipeline %s %s and has no failed jobs\n", in.PipelineID, state)
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+)
+
+// validateConfig checks a JSON repo config document the way the functions read it, see repoconfig
+func validateConfig(args []string) error {
+	flags := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: circleci-feedback validate-config <file.json>")
+		fmt.Fprintln(flags.Output(), "The file is the JSON document of the RepoConfig parameter or REPO_CONFIG_FILE, the settings per installation, repo and author")
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	b, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Error reading the repo config, error: %s", err)
+	}
+
+	f, err := repoconfig.Parse(b)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "%s is valid, it has settings for %d installations, %d repos and %d authors\n", flags.Arg(0), len(f.Installations), len(f.Repos), len(f.Authors))
+	return nil
+}
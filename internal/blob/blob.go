@@ -0,0 +1,46 @@
+// Package blob keeps data too bulky for the step function state, the state only holds the key it is stored under
+// Step Functions limits the state passed between steps to 256 KB and large pipelines easily have more job data than that
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned by Get when nothing is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs by key, keys are slash separated paths like jobs/<digest>.json
+type Store interface {
+	// Put stores data under key, replacing what was there
+	Put(key string, data []byte) error
+	// Get returns the data stored under key or ErrNotFound
+	Get(key string) ([]byte, error)
+}
+
+// NewStore returns the store for kind ("memory", "file" or "s3")
+// location is the directory for the file store and the bucket, optionally followed by /<prefix>, for the s3 store
+func NewStore(kind, location string) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		if location == "" {
+			return nil, fmt.Errorf("a directory is required for the file blob store")
+		}
+		return NewFileStore(location), nil
+	case "s3":
+		if location == "" {
+			return nil, fmt.Errorf("a bucket is required for the s3 blob store")
+		}
+		parts := strings.SplitN(location, "/", 2)
+		prefix := ""
+		if len(parts) == 2 {
+			prefix = parts[1]
+		}
+		return NewS3Store(parts[0], prefix)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q, expected one of memory, file or s3", kind)
+	}
+}
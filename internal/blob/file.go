@@ -0,0 +1,77 @@
+package blob
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps each blob in a file under Dir, this is meant for self hosting on a single machine
+type FileStore struct {
+	Dir string
+}
+
+// NewFileStore returns a FileStore keeping blobs under dir, directories are created as blobs are stored
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+// Put stores data under key
+// It writes to a temporary file first so a crash never leaves a half written blob behind
+func (s *FileStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("Error writing blob %s, error: %s", key, err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing blob %s, error: %s", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing blob %s, error: %s", key, err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get returns the data stored under key
+func (s *FileStore) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading blob %s, error: %s", key, err)
+	}
+
+	return data, nil
+}
+
+// path maps a key to a file under Dir, keys can't point outside of it
+func (s *FileStore) path(key string) (string, error) {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if key == "" || !strings.HasPrefix(path, filepath.Clean(s.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+
+	return path, nil
+}
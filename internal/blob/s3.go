@@ -0,0 +1,76 @@
+package blob
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Store keeps each blob as an object in Bucket, under Prefix when it is set
+// Blobs are never deleted by the app, use a lifecycle rule on the bucket to expire them
+type S3Store struct {
+	Bucket string
+	Prefix string
+
+	svc *s3.S3
+}
+
+// NewS3Store returns an S3Store for bucket using the default AWS session
+func NewS3Store(bucket, prefix string) (*S3Store, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{Bucket: bucket, Prefix: prefix, svc: s3.New(sess, aws.NewConfig())}, nil
+}
+
+// Put stores data under key
+func (s *S3Store) Put(key string, data []byte) error {
+	_, err := s.svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.key(key)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("Error writing blob %s to bucket %s, error: %s", key, s.Bucket, err)
+	}
+
+	return nil
+}
+
+// Get returns the data stored under key
+func (s *S3Store) Get(key string) ([]byte, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading blob %s from bucket %s, error: %s", key, s.Bucket, err)
+	}
+	defer out.Body.Close()
+
+	data, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading blob %s from bucket %s, error: %s", key, s.Bucket, err)
+	}
+
+	return data, nil
+}
+
+func (s *S3Store) key(key string) string {
+	if s.Prefix == "" {
+		return key
+	}
+	return path.Join(s.Prefix, key)
+}
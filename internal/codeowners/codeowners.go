@@ -0,0 +1,118 @@
+// Package codeowners finds the owners of files from a GitHub CODEOWNERS file
+// https://docs.github.com/en/repositories/managing-your-repositorys-settings-and-features/customizing-your-repository/about-code-owners
+package codeowners
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Locations are where GitHub looks for the CODEOWNERS file, in order
+var Locations = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// File is a parsed CODEOWNERS file
+type File struct {
+	rules []rule
+}
+
+type rule struct {
+	pattern *regexp.Regexp
+	owners  []string // @user, @org/team or email addresses
+}
+
+// Parse reads a CODEOWNERS file, lines it can't make sense of are skipped like GitHub does
+func Parse(b []byte) *File {
+	f := &File{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = stripComment(line)
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		pattern, err := regexp.Compile(toRegexp(fields[0]))
+		if err != nil {
+			continue
+		}
+		f.rules = append(f.rules, rule{pattern: pattern, owners: fields[1:]})
+	}
+
+	return f
+}
+
+// Owners returns the owners of path (relative to the root of the repo), the last matching rule wins
+// A matching rule without owners means the file has none
+func (f *File) Owners(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "./"), "/")
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		if f.rules[i].pattern.MatchString(path) {
+			return f.rules[i].owners
+		}
+	}
+
+	return nil
+}
+
+// stripComment cuts line at the first # that isn't escaped as \#
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// toRegexp converts a gitignore style pattern to a regular expression matching the paths it covers
+func toRegexp(pattern string) string {
+	// a pattern with a slash anywhere but the end is relative to the root, otherwise it matches at any depth
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.Trim(pattern, "/")
+
+	expr := ""
+	endsInGlob := false
+	for i := 0; i < len(pattern); i++ {
+		endsInGlob = true
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			// \# and \* are the characters themselves
+			i++
+			expr = expr + regexp.QuoteMeta(pattern[i:i+1])
+			endsInGlob = false
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr = expr + "(?:.*/)?"
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr = expr + ".*"
+			i++
+		case pattern[i] == '*':
+			expr = expr + "[^/]*"
+		case pattern[i] == '?':
+			expr = expr + "[^/]"
+		default:
+			expr = expr + regexp.QuoteMeta(pattern[i:i+1])
+			endsInGlob = false
+		}
+	}
+
+	prefix := "^(?:.*/)?"
+	if anchored {
+		prefix = "^"
+	}
+
+	// a pattern naming a directory covers everything in it, one ending in a glob like docs/* only covers what the
+	// glob matches so docs/* doesn't cover docs/build/app.md
+	switch {
+	case dirOnly:
+		return prefix + expr + "/.*$"
+	case endsInGlob:
+		return prefix + expr + "$"
+	default:
+		return prefix + expr + "(?:/.*)?$"
+	}
+}
@@ -0,0 +1,79 @@
+package codeowners
+
+import (
+	"strings"
+	"testing"
+)
+
+// example is the example CODEOWNERS file of the GitHub docs, with an escaped # added
+const example = `# This is a comment.
+# Each line is a file pattern followed by one or more owners.
+
+*       @global-owner1 @global-owner2
+*.js    @js-owner #This is an inline comment.
+*.go docs@example.com
+*.txt @octo-org/octocats
+/build/logs/ @doctocat
+docs/*  docs@example.com
+apps/ @octocat
+/docs/ @doctocat
+/scripts/ @doctocat @octocat
+**/logs @octocat
+/apps/ @octocat
+/apps/github
+\#notes.md @hash-owner
+`
+
+func TestGitHubExamples(t *testing.T) {
+	f := Parse([]byte(example))
+
+	tests := []struct {
+		path   string
+		owners string
+	}{
+		{"README.md", "@global-owner1 @global-owner2"},
+		{"src/app.js", "@js-owner"},
+		{"main.go", "docs@example.com"},
+		{"notes/todo.txt", "@octo-org/octocats"},
+		{"build/logs/today.log", "@octocat"}, // **/logs comes later
+		{"build/output.bin", "@global-owner1 @global-owner2"},
+		// docs/* covers the files of docs but not nested ones, /docs/ covers those
+		{"docs/getting-started.md", "@doctocat"},
+		{"docs/build-app/troubleshooting.md", "@doctocat"},
+		{"web/docs/getting-started.md", "@global-owner1 @global-owner2"},
+		{"web/apps/main.rb", "@octocat"},
+		{"scripts/deploy.sh", "@doctocat @octocat"},
+		{"deeply/nested/logs/x.log", "@octocat"},
+		{"apps/github/main.rb", ""},
+		{"apps/gitlab/main.rb", "@octocat"},
+		{"#notes.md", "@hash-owner"},
+	}
+
+	for _, tt := range tests {
+		got := strings.Join(f.Owners(tt.path), " ")
+		if got != tt.owners {
+			t.Errorf("Expected %s to be owned by %q, got %q", tt.path, tt.owners, got)
+		}
+	}
+}
+
+func TestGlobPatternsDontCoverNestedFiles(t *testing.T) {
+	f := Parse([]byte("docs/* @docs\nsrc/*.go @go\n"))
+
+	tests := []struct {
+		path   string
+		owners string
+	}{
+		{"docs/getting-started.md", "@docs"},
+		{"docs/build-app/troubleshooting.md", ""},
+		{"src/main.go", "@go"},
+		{"src/cmd/main.go", ""},
+	}
+
+	for _, tt := range tests {
+		got := strings.Join(f.Owners(tt.path), " ")
+		if got != tt.owners {
+			t.Errorf("Expected %s to be owned by %q, got %q", tt.path, tt.owners, got)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package dedup keeps track of webhook deliveries that were already handled so
+// redelivered or retried webhooks don't start duplicate step function executions
+package dedup
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultTTL is how long a key is remembered when no TTL is configured
+// GitHub only allows redelivering webhooks from the last few days so this comfortably covers retries
+const DefaultTTL = 72 * time.Hour
+
+// Store records keys that have been seen, a key is forgotten once its TTL expires
+type Store interface {
+	// Claim records the key and reports whether it was claimed by this call,
+	// false means the key was already claimed and hasn't expired yet
+	Claim(key string, ttl time.Duration) (bool, error)
+	// Release forgets a key so a later delivery can claim it again, used when handling the delivery failed
+	Release(key string) error
+}
+
+// NewStore returns the store for kind ("memory", "file" or "dynamodb")
+// location is the file path for the file store and the table name for the dynamodb store
+func NewStore(kind, location string) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		if location == "" {
+			return nil, fmt.Errorf("a file path is required for the file dedup store")
+		}
+		return NewFileStore(location), nil
+	case "dynamodb":
+		if location == "" {
+			return nil, fmt.Errorf("a table name is required for the dynamodb dedup store")
+		}
+		return NewDynamoDBStore(location)
+	default:
+		return nil, fmt.Errorf("unknown dedup store %q, expected one of memory, file or dynamodb", kind)
+	}
+}
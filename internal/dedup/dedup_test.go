@@ -0,0 +1,141 @@
+package dedup
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// clock is a time that tests move forward
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func testStore(t *testing.T, s Store, c *clock) {
+	claimed, err := s.Claim("delivery/1", time.Hour)
+	if err != nil || !claimed {
+		t.Fatalf("Expected the first claim to succeed, got %v, error: %v", claimed, err)
+	}
+
+	claimed, err = s.Claim("delivery/1", time.Hour)
+	if err != nil || claimed {
+		t.Errorf("Expected a second claim before the TTL to fail, got %v, error: %v", claimed, err)
+	}
+
+	claimed, err = s.Claim("delivery/2", time.Hour)
+	if err != nil || !claimed {
+		t.Errorf("Expected another key to be claimed, got %v, error: %v", claimed, err)
+	}
+
+	c.t = c.t.Add(time.Hour)
+	claimed, err = s.Claim("delivery/1", time.Hour)
+	if err != nil || !claimed {
+		t.Errorf("Expected a claim after the TTL to succeed, got %v, error: %v", claimed, err)
+	}
+
+	err = s.Release("delivery/1")
+	if err != nil {
+		t.Fatalf("Expected the release to succeed, got %s", err)
+	}
+	claimed, err = s.Claim("delivery/1", time.Hour)
+	if err != nil || !claimed {
+		t.Errorf("Expected a released key to be claimed again, got %v, error: %v", claimed, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	c := &clock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	s := NewMemoryStore()
+	s.now = c.now
+
+	testStore(t, s, c)
+}
+
+func TestFileStore(t *testing.T) {
+	c := &clock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	path := filepath.Join(t.TempDir(), "deliveries.json")
+	s := NewFileStore(path)
+	s.now = c.now
+
+	testStore(t, s, c)
+
+	// another store on the same file, like after a restart, knows the claims
+	restarted := NewFileStore(path)
+	restarted.now = c.now
+	claimed, err := restarted.Claim("delivery/1", time.Hour)
+	if err != nil || claimed {
+		t.Errorf("Expected the claims to be kept in the file, got %v, error: %v", claimed, err)
+	}
+}
+
+func TestDynamoDBClaimRequest(t *testing.T) {
+	var target string
+	var input map[string]interface{}
+	conditionFails := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		b, _ := io.ReadAll(r.Body)
+		input = map[string]interface{}{}
+		json.Unmarshal(b, &input)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if conditionFails {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "The conditional request failed"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	now := time.Unix(1600000000, 0)
+	s := &DynamoDBStore{Table: "deliveries", svc: dynamodb.New(sess), now: func() time.Time { return now }}
+
+	claimed, err := s.Claim("delivery/1", time.Hour)
+	if err != nil || !claimed {
+		t.Fatalf("Expected the claim to succeed, got %v, error: %v", claimed, err)
+	}
+
+	if target != "DynamoDB_20120810.PutItem" || input["TableName"] != "deliveries" {
+		t.Errorf("Expected a PutItem on deliveries, got %s %v", target, input["TableName"])
+	}
+	// an expired item that TTL hasn't deleted yet can be claimed again
+	if input["ConditionExpression"] != "attribute_not_exists(id) OR expires_at <= :now" {
+		t.Errorf("Expected the claim to be conditional on the key being missing or expired, got %v", input["ConditionExpression"])
+	}
+	item, _ := json.Marshal(input["Item"])
+	if string(item) != `{"expires_at":{"N":"1600003600"},"id":{"S":"delivery/1"}}` {
+		t.Errorf("Expected the key with its expiry, got %s", item)
+	}
+	values, _ := json.Marshal(input["ExpressionAttributeValues"])
+	if string(values) != `{":now":{"N":"1600000000"}}` {
+		t.Errorf("Expected :now to be the current time, got %s", values)
+	}
+
+	conditionFails = true
+	claimed, err = s.Claim("delivery/1", time.Hour)
+	if err != nil || claimed {
+		t.Errorf("Expected a failed condition to mean the key is claimed, got %v, error: %v", claimed, err)
+	}
+
+	conditionFails = false
+	err = s.Release("delivery/1")
+	if err != nil || target != "DynamoDB_20120810.DeleteItem" {
+		t.Errorf("Expected a DeleteItem, got %s, error: %v", target, err)
+	}
+}
@@ -0,0 +1,68 @@
+package dedup
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDBStore keeps claimed keys in a DynamoDB table with a string hash key named "id"
+// Items carry an "expires_at" epoch timestamp, enable DynamoDB TTL on that attribute to have expired items cleaned up
+type DynamoDBStore struct {
+	Table string
+
+	svc *dynamodb.DynamoDB
+	now func() time.Time
+}
+
+// NewDynamoDBStore returns a DynamoDBStore for table using the default AWS session
+func NewDynamoDBStore(table string) (*DynamoDBStore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DynamoDBStore{Table: table, svc: dynamodb.New(sess, aws.NewConfig()), now: time.Now}, nil
+}
+
+// Claim records the key and reports whether it was claimed by this call
+// The conditional put makes the claim atomic across concurrent lambda invocations,
+// DynamoDB TTL deletes lazily so expired items are treated as missing
+func (s *DynamoDBStore) Claim(key string, ttl time.Duration) (bool, error) {
+	now := s.now()
+
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":         {S: aws.String(key)},
+			"expires_at": {N: aws.String(strconv.FormatInt(now.Add(ttl).Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id) OR expires_at <= :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Release forgets a key
+func (s *DynamoDBStore) Release(key string) error {
+	_, err := s.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(key)},
+		},
+	})
+	return err
+}
@@ -0,0 +1,111 @@
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStore keeps claimed keys in a JSON file, this is meant for self hosting on a single machine
+// It is safe for concurrent use within one process but not across processes sharing the file
+type FileStore struct {
+	Path string
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewFileStore returns a FileStore backed by the file at path, the file is created on the first claim
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path, now: time.Now}
+}
+
+// Claim records the key and reports whether it was claimed by this call
+func (s *FileStore) Claim(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, err := s.load()
+	if err != nil {
+		return false, err
+	}
+
+	now := s.now()
+	for k, e := range expires {
+		if !now.Before(e) {
+			delete(expires, k)
+		}
+	}
+
+	if _, ok := expires[key]; ok {
+		return false, nil
+	}
+
+	expires[key] = now.Add(ttl)
+	return true, s.save(expires)
+}
+
+// Release forgets a key
+func (s *FileStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	delete(expires, key)
+	return s.save(expires)
+}
+
+func (s *FileStore) load() (map[string]time.Time, error) {
+	expires := map[string]time.Time{}
+
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return expires, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading dedup file %s, error: %s", s.Path, err)
+	}
+
+	if len(b) == 0 {
+		return expires, nil
+	}
+
+	err = json.Unmarshal(b, &expires)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing dedup file %s, error: %s", s.Path, err)
+	}
+
+	return expires, nil
+}
+
+// save writes to a temporary file first so a crash never leaves a half written file behind
+func (s *FileStore) save(expires map[string]time.Time) error {
+	b, err := json.Marshal(expires)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing dedup file %s, error: %s", s.Path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing dedup file %s, error: %s", s.Path, err)
+	}
+
+	return os.Rename(tmp.Name(), s.Path)
+}
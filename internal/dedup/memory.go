@@ -0,0 +1,48 @@
+package dedup
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps claimed keys in memory
+// In lambda this only deduplicates deliveries that land on the same warm container
+type MemoryStore struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	now     func() time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{expires: map[string]time.Time{}, now: time.Now}
+}
+
+// Claim records the key and reports whether it was claimed by this call
+func (s *MemoryStore) Claim(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for k, expires := range s.expires {
+		if !now.Before(expires) {
+			delete(s.expires, k)
+		}
+	}
+
+	if _, ok := s.expires[key]; ok {
+		return false, nil
+	}
+
+	s.expires[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Release forgets a key
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.expires, key)
+	return nil
+}
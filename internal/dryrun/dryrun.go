@@ -0,0 +1,193 @@
+// Package dryrun keeps the comments the app would post on GitHub for the installations and repos
+// that are in dry run, so the app can be tried on a new org without anyone seeing it
+//
+// Reads still go to GitHub, only writes are kept, turn dry run on with "dry_run": true in the repo config
+package dryrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+	"github.com/google/go-github/github"
+)
+
+// ErrNotFound is returned by Get when no comment is kept under an ID
+var ErrNotFound = errors.New("dry run comment not found")
+
+// Comment is a comment that would have been posted, or a comment edit that would have been made
+type Comment struct {
+	// ID is made up and negative so it is never mistaken for the ID of a comment on GitHub
+	ID             int64     `json:"id"`
+	InstallationID int64     `json:"installation_id"`
+	Repo           string    `json:"repo"` // owner/repo
+	Number         int       `json:"number"`
+	Body           string    `json:"body"`
+	Edits          int       `json:"edits"`             // times the comment would have been edited
+	EditOf         int64     `json:"edit_of,omitempty"` // the comment on GitHub that would have been edited, when there is one
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Store keeps dry run comments
+type Store interface {
+	// Save adds a comment or replaces the one with the same ID
+	Save(c Comment) error
+	// Get returns the comment with id on repo (owner/repo) or ErrNotFound
+	Get(repo string, id int64) (Comment, error)
+	// Recent returns up to limit of the most recently created comments on repo, newest first,
+	// or on every repo when repo is empty
+	Recent(repo string, limit int) ([]Comment, error)
+}
+
+// NewStore returns the store for kind ("memory", "file" or "dynamodb")
+// location is the file path for the file store and the table name for the dynamodb store
+func NewStore(kind, location string) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		if location == "" {
+			return nil, fmt.Errorf("a file path is required for the file dry run store")
+		}
+		return NewFileStore(location), nil
+	case "dynamodb":
+		if location == "" {
+			return nil, fmt.Errorf("a table name is required for the dynamodb dry run store")
+		}
+		return NewDynamoDBStore(location)
+	default:
+		return nil, fmt.Errorf("unknown dry run store %q, expected one of memory, file or dynamodb", kind)
+	}
+}
+
+// Wrap returns api, or a GitHub that keeps the writes to repo (owner/repo) in store when settings turn dry run on
+func Wrap(api githubapp.API, settings repoconfig.Settings, store Store, installationID int64, logger *slog.Logger) githubapp.API {
+	if !settings.DryRunEnabled() {
+		return api
+	}
+
+	return &GitHub{API: api, Store: store, InstallationID: installationID, Logger: logger}
+}
+
+// GitHub passes reads through to API and keeps the comments it is asked to post or edit in Store instead
+// With no Store the comments are only logged
+type GitHub struct {
+	githubapp.API
+	Store          Store
+	InstallationID int64
+	Logger         *slog.Logger
+
+	now func() time.Time
+}
+
+func (g *GitHub) time() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+// newID is the negated time in nanoseconds, unique across function instances and smaller for newer comments
+func (g *GitHub) newID() int64 {
+	return -g.time().UnixNano()
+}
+
+// CreateComment keeps the comment instead of posting it
+func (g *GitHub) CreateComment(ctx context.Context, owner, repo string, number int, body string) (*github.IssueComment, error) {
+	now := g.time()
+	c := Comment{
+		ID:             g.newID(),
+		InstallationID: g.InstallationID,
+		Repo:           owner + "/" + repo,
+		Number:         number,
+		Body:           body,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	err := g.save(c, "create")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.api(), nil
+}
+
+// GetComment returns a kept comment for the made up IDs and the comment on GitHub for the others
+func (g *GitHub) GetComment(ctx context.Context, owner, repo string, id int64) (*github.IssueComment, error) {
+	if id > 0 || g.Store == nil {
+		return g.API.GetComment(ctx, owner, repo, id)
+	}
+
+	c, err := g.Store.Get(owner+"/"+repo, id)
+	if err == ErrNotFound {
+		return nil, githubapp.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.api(), nil
+}
+
+// EditComment keeps the new body of a kept comment, an edit of a comment on GitHub is kept as a new comment
+func (g *GitHub) EditComment(ctx context.Context, owner, repo string, id int64, body string) (*github.IssueComment, error) {
+	c := Comment{}
+	var err error
+	if id < 0 && g.Store != nil {
+		c, err = g.Store.Get(owner+"/"+repo, id)
+		if err == ErrNotFound {
+			return nil, githubapp.ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.Edits++
+	} else {
+		c = Comment{ID: g.newID(), InstallationID: g.InstallationID, Repo: owner + "/" + repo, EditOf: id, CreatedAt: g.time()}
+	}
+	c.Body = body
+	c.UpdatedAt = g.time()
+
+	err = g.save(c, "edit")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.api(), nil
+}
+
+func (g *GitHub) save(c Comment, action string) error {
+	logger := g.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Info("Dry run, keeping the comment instead of posting it", "action", action, "comment_id", c.ID, "repo", c.Repo, "number", c.Number, "body", c.Body)
+
+	if g.Store == nil {
+		return nil
+	}
+
+	err := g.Store.Save(c)
+	if err != nil {
+		return fmt.Errorf("Error keeping the dry run comment, error: %s", err)
+	}
+
+	return nil
+}
+
+func (c Comment) api() *github.IssueComment {
+	id, body := c.ID, c.Body
+	return &github.IssueComment{ID: &id, Body: &body}
+}
+
+// matches reports whether c is on repo, every comment is when repo is empty
+func (c Comment) matches(repo string) bool {
+	return repo == "" || strings.EqualFold(c.Repo, repo)
+}
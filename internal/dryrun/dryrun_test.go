@@ -0,0 +1,68 @@
+package dryrun
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakes"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+)
+
+func TestWrapWithoutDryRun(t *testing.T) {
+	gh := &fakes.GitHub{}
+	if api := Wrap(gh, repoconfig.Settings{}, NewMemoryStore(), 1, nil); api != gh {
+		t.Errorf("Expected the client to be used as is, got %T", api)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "dry-runs.json"))
+	clock := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dryRun := true
+	api := Wrap(&fakes.GitHub{}, repoconfig.Settings{DryRun: &dryRun}, store, 1, nil).(*GitHub)
+	api.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	ctx := context.Background()
+
+	first, err := api.CreateComment(ctx, "octo", "app", 7, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = api.CreateComment(ctx, "octo", "other", 1, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = api.EditComment(ctx, "octo", "app", first.GetID(), "first, edited")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := api.GetComment(ctx, "octo", "app", first.GetID())
+	if err != nil || got.GetBody() != "first, edited" {
+		t.Errorf("Expected the edited comment, got %q, error: %v", got.GetBody(), err)
+	}
+	_, err = api.GetComment(ctx, "octo", "other", first.GetID())
+	if err != githubapp.ErrNotFound {
+		t.Errorf("Expected comments of other repos not to be found, got %v", err)
+	}
+
+	all, err := store.Recent("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Body != "second" || all[1].Edits != 1 {
+		t.Errorf("Expected both comments newest first, got %+v", all)
+	}
+	one, err := store.Recent("octo/app", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(one) != 1 || one[0].Number != 7 {
+		t.Errorf("Expected the comment on octo/app, got %+v", one)
+	}
+}
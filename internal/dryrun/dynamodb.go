@@ -0,0 +1,136 @@
+package dryrun
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// commentTTL is how long comments are kept, enable DynamoDB TTL on expires_at to have them cleaned up
+const commentTTL = 30 * 24 * time.Hour
+
+// DynamoDBStore keeps the comments in a DynamoDB table with a string hash key named "repo" and a number range key named "id"
+// Comment IDs get smaller as they get newer, so a repo's items are in newest first order
+// The repo key is lower-cased, GitHub repo names aren't case sensitive and the other stores match them either way
+type DynamoDBStore struct {
+	Table string
+
+	svc *dynamodb.DynamoDB
+}
+
+// NewDynamoDBStore returns a DynamoDBStore for table using the default AWS session
+func NewDynamoDBStore(table string) (*DynamoDBStore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DynamoDBStore{Table: table, svc: dynamodb.New(sess, aws.NewConfig())}, nil
+}
+
+// Save adds a comment or replaces the one with the same ID
+func (s *DynamoDBStore) Save(c Comment) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"repo":       {S: aws.String(repoKey(c.Repo))},
+			"id":         {N: aws.String(strconv.FormatInt(c.ID, 10))},
+			"comment":    {S: aws.String(string(b))},
+			"expires_at": {N: aws.String(strconv.FormatInt(c.CreatedAt.Add(commentTTL).Unix(), 10))},
+		},
+	})
+	return err
+}
+
+// Get returns the comment with id on repo or ErrNotFound
+func (s *DynamoDBStore) Get(repo string, id int64) (Comment, error) {
+	resp, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"repo": {S: aws.String(repoKey(repo))},
+			"id":   {N: aws.String(strconv.FormatInt(id, 10))},
+		},
+	})
+	if err != nil {
+		return Comment{}, err
+	}
+	if resp.Item == nil {
+		return Comment{}, ErrNotFound
+	}
+
+	return parseItem(resp.Item)
+}
+
+// Recent returns up to limit of the most recently created comments on repo, newest first
+// Without a repo the whole table is scanned, dry run tables are small since comments expire
+func (s *DynamoDBStore) Recent(repo string, limit int) ([]Comment, error) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	if repo != "" {
+		resp, err := s.svc.Query(&dynamodb.QueryInput{
+			TableName:              aws.String(s.Table),
+			KeyConditionExpression: aws.String("#repo = :repo"),
+			ExpressionAttributeNames: map[string]*string{
+				"#repo": aws.String("repo"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":repo": {S: aws.String(repoKey(repo))},
+			},
+			ScanIndexForward: aws.Bool(true),
+			Limit:            aws.Int64(int64(limit)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = resp.Items
+	} else {
+		err := s.svc.ScanPages(&dynamodb.ScanInput{TableName: aws.String(s.Table)}, func(page *dynamodb.ScanOutput, last bool) bool {
+			items = append(items, page.Items...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	comments := []Comment{}
+	for _, item := range items {
+		c, err := parseItem(item)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].CreatedAt.After(comments[j].CreatedAt) })
+	if len(comments) > limit {
+		comments = comments[:limit]
+	}
+
+	return comments, nil
+}
+
+// repoKey is the value of the repo key of the comments on repo
+func repoKey(repo string) string {
+	return strings.ToLower(repo)
+}
+
+func parseItem(item map[string]*dynamodb.AttributeValue) (Comment, error) {
+	c := Comment{}
+	if item["comment"] == nil {
+		return c, nil
+	}
+
+	err := json.Unmarshal([]byte(aws.StringValue(item["comment"].S)), &c)
+	return c, err
+}
@@ -0,0 +1,67 @@
+package dryrun
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestDynamoDBRepoKeyIsLowerCased(t *testing.T) {
+	var target string
+	var input map[string]interface{}
+	response := `{}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		b, _ := io.ReadAll(r.Body)
+		input = map[string]interface{}{}
+		json.Unmarshal(b, &input)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	s := &DynamoDBStore{Table: "dry-runs", svc: dynamodb.New(sess)}
+
+	err := s.Save(Comment{ID: -1, Repo: "Octo/App", Body: "first", CreatedAt: time.Unix(1600000000, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, _ := json.Marshal(input["Item"].(map[string]interface{})["repo"])
+	if target != "DynamoDB_20120810.PutItem" || string(item) != `{"S":"octo/app"}` {
+		t.Errorf("Expected a PutItem with the lower-cased repo, got %s %s", target, item)
+	}
+
+	_, err = s.Get("OCTO/app", -1)
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing item, got %v", err)
+	}
+	key, _ := json.Marshal(input["Key"])
+	if string(key) != `{"id":{"N":"-1"},"repo":{"S":"octo/app"}}` {
+		t.Errorf("Expected the lower-cased repo in the key, got %s", key)
+	}
+
+	response = `{"Items": [{"repo": {"S": "octo/app"}, "id": {"N": "-1"}, "comment": {"S": "{\"id\": -1, \"repo\": \"Octo/App\", \"body\": \"first\"}"}}]}`
+	comments, err := s.Recent("octo/APP", 10)
+	if err != nil || len(comments) != 1 || comments[0].Body != "first" {
+		t.Errorf("Expected the comment, got %+v, error: %v", comments, err)
+	}
+	values, _ := json.Marshal(input["ExpressionAttributeValues"])
+	if target != "DynamoDB_20120810.Query" || string(values) != `{":repo":{"S":"octo/app"}}` {
+		t.Errorf("Expected a Query on the lower-cased repo, got %s %s", target, values)
+	}
+}
@@ -0,0 +1,109 @@
+package dryrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps the comments in a JSON file, this is meant for self hosting on a single machine
+// It is safe for concurrent use within one process but not across processes sharing the file
+type FileStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFileStore returns a FileStore backed by the file at path, the file is created on the first save
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+// Save adds a comment or replaces the one with the same ID
+func (s *FileStore) Save(c Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comments, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	return s.write(save(comments, c))
+}
+
+// Get returns the comment with id on repo or ErrNotFound
+func (s *FileStore) Get(repo string, id int64) (Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comments, err := s.load()
+	if err != nil {
+		return Comment{}, err
+	}
+
+	return get(comments, repo, id)
+}
+
+// Recent returns up to limit of the most recently created comments on repo, newest first
+func (s *FileStore) Recent(repo string, limit int) ([]Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comments, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return recent(comments, repo, limit), nil
+}
+
+func (s *FileStore) load() ([]Comment, error) {
+	comments := []Comment{}
+
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return comments, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading dry run file %s, error: %s", s.Path, err)
+	}
+
+	if len(b) == 0 {
+		return comments, nil
+	}
+
+	err = json.Unmarshal(b, &comments)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing dry run file %s, error: %s", s.Path, err)
+	}
+
+	return comments, nil
+}
+
+// write writes to a temporary file first so a crash never leaves a half written file behind
+func (s *FileStore) write(comments []Comment) error {
+	b, err := json.Marshal(comments)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing dry run file %s, error: %s", s.Path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing dry run file %s, error: %s", s.Path, err)
+	}
+
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -0,0 +1,88 @@
+package dryrun
+
+import (
+	"sort"
+	"sync"
+)
+
+// maxComments is how many comments the memory and file stores keep, the oldest are dropped first
+const maxComments = 500
+
+// MemoryStore keeps the comments in memory
+// In lambda the comments only live as long as the warm container
+type MemoryStore struct {
+	mu       sync.Mutex
+	comments []Comment
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Save adds a comment or replaces the one with the same ID
+func (s *MemoryStore) Save(c Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.comments = save(s.comments, c)
+	return nil
+}
+
+// Get returns the comment with id on repo or ErrNotFound
+func (s *MemoryStore) Get(repo string, id int64) (Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return get(s.comments, repo, id)
+}
+
+// Recent returns up to limit of the most recently created comments on repo, newest first
+func (s *MemoryStore) Recent(repo string, limit int) ([]Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return recent(s.comments, repo, limit), nil
+}
+
+// save replaces or adds c keeping comments sorted newest first and capped at maxComments
+func save(comments []Comment, c Comment) []Comment {
+	for i := range comments {
+		if comments[i].ID == c.ID {
+			comments[i] = c
+			return comments
+		}
+	}
+
+	comments = append(comments, c)
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].CreatedAt.After(comments[j].CreatedAt) })
+	if len(comments) > maxComments {
+		comments = comments[:maxComments]
+	}
+
+	return comments
+}
+
+func get(comments []Comment, repo string, id int64) (Comment, error) {
+	for _, c := range comments {
+		if c.ID == id && c.matches(repo) {
+			return c, nil
+		}
+	}
+
+	return Comment{}, ErrNotFound
+}
+
+func recent(comments []Comment, repo string, limit int) []Comment {
+	found := []Comment{}
+	for _, c := range comments {
+		if len(found) == limit {
+			break
+		}
+		if c.matches(repo) {
+			found = append(found, c)
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,361 @@
+package entry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/codingdiaz/circleci-feedback/internal/dryrun"
+	"github.com/codingdiaz/circleci-feedback/internal/feedback"
+	"github.com/codingdiaz/circleci-feedback/internal/logging"
+	"github.com/codingdiaz/circleci-feedback/internal/metrics"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/tracing"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+	"github.com/google/go-github/github"
+)
+
+const (
+	commandPrefix = "/circleci"
+	commandUsage  = "Usage:\n" +
+		"* `/circleci rerun failed` reruns the failed jobs of every failed workflow\n" +
+		"* `/circleci rerun <job>` reruns a job\n" +
+		"* `/circleci logs <job>` posts the full output of a failed job\n" +
+		"* `/circleci cancel` cancels the running workflows"
+)
+
+// command is a /circleci command from a pull request comment
+type command struct {
+	Name string
+	Args []string
+}
+
+func (cmd command) String() string {
+	return strings.Join(append([]string{commandPrefix, cmd.Name}, cmd.Args...), " ")
+}
+
+// parseCommands finds the /circleci commands in a comment, every command is on its own line
+func parseCommands(body string) []command {
+	commands := []command{}
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != commandPrefix {
+			continue
+		}
+
+		cmd := command{}
+		if len(fields) > 1 {
+			cmd.Name = strings.ToLower(fields[1])
+			cmd.Args = fields[2:]
+		}
+		commands = append(commands, cmd)
+	}
+
+	return commands
+}
+
+// commandContext is what a command acts on, the pipeline built for the head commit of the pull request
+type commandContext struct {
+	ctx       context.Context
+	config    stepfunc.Config
+	start     ExecutionStarter
+	circle    circleci.API
+	logger    *slog.Logger
+	watch     stepfunc.Data
+	owner     string
+	repo      string
+	sha       string
+	workflows []feedback.WorkflowJobs
+	dryRun    bool // commands only reply with what they would change on CircleCI
+}
+
+// handleIssueComment runs the /circleci commands in new pull request comments
+func (h *Handler) handleIssueComment(ctx context.Context, request events.APIGatewayProxyRequest, c stepfunc.Config, logger *slog.Logger) events.APIGatewayProxyResponse {
+
+	event := github.IssueCommentEvent{}
+	err := json.Unmarshal([]byte(request.Body), &event)
+	if err != nil {
+		logger.Error("Unable to unmarshal request body into go struct", "error", err)
+		return events.APIGatewayProxyResponse{Body: request.Body, StatusCode: 200}
+	}
+
+	// only new comments on pull requests can hold commands, and the app never answers bots (itself included)
+	if event.GetAction() != "created" || !event.GetIssue().IsPullRequest() || event.GetSender().GetType() == "Bot" {
+		return events.APIGatewayProxyResponse{StatusCode: 200}
+	}
+
+	commands := parseCommands(event.GetComment().GetBody())
+	if len(commands) == 0 {
+		return events.APIGatewayProxyResponse{StatusCode: 200}
+	}
+
+	owner := event.GetRepo().GetOwner().GetLogin()
+	repo := event.GetRepo().GetName()
+	number := event.GetIssue().GetNumber()
+	login := event.GetSender().GetLogin()
+	logger = logger.With(logging.Owner, owner, logging.Repo, repo, logging.PullRequest, number, "login", login)
+
+	githubClient, err := h.GitHub.Installation(event.GetInstallation().GetID())
+	if err != nil {
+		logger.Error("Unable to create authenticated github client", "error", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500}
+	}
+	settings := c.Repos.For(event.GetInstallation().GetID(), owner+"/"+repo)
+	githubClient = dryrun.Wrap(githubClient, settings, h.DryRuns, event.GetInstallation().GetID(), logger)
+
+	reply := func(body string) bool {
+		_, err := githubClient.CreateComment(ctx, owner, repo, number, body)
+		if err != nil {
+			logger.Error("Unable to post a reply to a command on the PR", "error", err)
+			return false
+		}
+		metrics.CommentsPosted.Inc("command_reply", "create")
+		return true
+	}
+
+	// commands rerun and cancel builds, only people who can push to the repo get to run them
+	level, err := githubClient.GetPermissionLevel(ctx, owner, repo, login)
+	if err != nil {
+		logger.Error("Unable to get the permission level of the commenter", "error", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500}
+	}
+	if level != "admin" && level != "write" {
+		logger.Info("The commenter can't push to the repo, not running their commands", "permission", level)
+		if !reply(fmt.Sprintf("@%s you need write access to this repository to run `%s` commands", login, commandPrefix)) {
+			return events.APIGatewayProxyResponse{StatusCode: 500}
+		}
+		return events.APIGatewayProxyResponse{StatusCode: 200}
+	}
+
+	pr, err := githubClient.GetPullRequest(ctx, owner, repo, number)
+	if err != nil {
+		logger.Error("Unable to get the pull request", "error", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500}
+	}
+
+	logger = logger.With(logging.SHA, pr.GetHead().GetSHA())
+	circle := h.CircleCI.Client(ctx, c, logger)
+	cc := &commandContext{
+		ctx:    ctx,
+		config: c,
+		start:  h.Executions,
+		circle: circle,
+		logger: logger,
+		owner:  owner,
+		repo:   repo,
+		sha:    pr.GetHead().GetSHA(),
+		dryRun: settings.DryRunEnabled(),
+	}
+
+	pipeline, err := feedback.FindPipeline(cc.circle, c.CircleVCS, owner, repo, pr.GetHead().GetRef(), cc.sha)
+	if err == nil {
+		cc.workflows, err = feedback.PipelineWorkflows(ctx, cc.circle, pipeline, c.CircleConcurrency)
+	}
+	if err != nil {
+		logger.Error("Unable to find the pipeline", "error", err)
+		if !reply(fmt.Sprintf("@%s I couldn't find the CircleCI pipeline for %s: %s", login, cc.sha, err)) {
+			return events.APIGatewayProxyResponse{StatusCode: 500}
+		}
+		return events.APIGatewayProxyResponse{StatusCode: 200}
+	}
+
+	// reruns are watched like new commits, starting from the workflows they create
+	cc.watch = stepfunc.Data{
+		CorrelationID:     request.Headers["X-GitHub-Delivery"],
+		GitHubHost:        c.GitHubHost,
+		InstallationID:    int(event.GetInstallation().GetID()),
+		CommitSHA:         cc.sha,
+		Branch:            pr.GetHead().GetRef(),
+		BaseBranch:        pr.GetBase().GetRef(),
+		RepoName:          repo,
+		Owner:             owner,
+		PullRequestNumber: number,
+		Author:            pr.GetUser().GetLogin(),
+		PipelineID:        pipeline.ID,
+		TraceParent:       tracing.TraceParent(ctx),
+	}
+
+	// a failing command is answered on the PR rather than failing the request,
+	// a retried delivery would otherwise run the commands before it again
+	for _, cmd := range commands {
+		logger.Info("Running a command", "command", cmd.String())
+		replies, err := cc.run(cmd)
+		if err != nil {
+			logger.Error("Error running a command", "command", cmd.String(), "error", err)
+			replies = []string{fmt.Sprintf("`%s` failed: %s", cmd, err)}
+		}
+
+		for _, r := range replies {
+			if !reply(fmt.Sprintf("@%s %s", login, r)) {
+				return events.APIGatewayProxyResponse{StatusCode: 500}
+			}
+		}
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: 200}
+}
+
+// run runs a command and returns the replies to post
+func (cc *commandContext) run(cmd command) ([]string, error) {
+	switch {
+	case cmd.Name == "rerun" && len(cmd.Args) == 1 && cmd.Args[0] == "failed":
+		return cc.rerunFailed()
+	case cmd.Name == "rerun" && len(cmd.Args) == 1:
+		return cc.rerunJob(cmd.Args[0])
+	case cmd.Name == "logs" && len(cmd.Args) == 1:
+		return cc.logs(cmd.Args[0])
+	case cmd.Name == "cancel" && len(cmd.Args) == 0:
+		return cc.cancel()
+	default:
+		return []string{fmt.Sprintf("I don't know `%s`.\n%s", cmd, commandUsage)}, nil
+	}
+}
+
+// findJob finds the jobs named name in every workflow of the pipeline
+func (cc *commandContext) findJob(name string) []feedback.WorkflowJobs {
+	found := []feedback.WorkflowJobs{}
+	for _, w := range cc.workflows {
+		for _, job := range w.Jobs {
+			if job.Name == name {
+				found = append(found, feedback.WorkflowJobs{Workflow: w.Workflow, Jobs: []circleci.Job{job}})
+			}
+		}
+	}
+
+	return found
+}
+
+// follow starts an execution that watches the workflows a rerun created and reports their failures
+func (cc *commandContext) follow(workflowID string) error {
+	input := cc.watch
+	input.WorkflowIDs = []string{workflowID}
+
+	err := cc.start.StartExecution(input, rerunExecutionName(input, workflowID))
+	if err != nil {
+		return fmt.Errorf("Error starting step function for workflow %s, error: %s", workflowID, err)
+	}
+
+	return nil
+}
+
+func (cc *commandContext) rerun(workflow *circleci.Workflow, opts circleci.RerunWorkflowOptions) error {
+	if cc.dryRun {
+		cc.logger.Info("Dry run, not rerunning a workflow", logging.WorkflowID, workflow.ID, "jobs", opts.Jobs, "from_failed", opts.FromFailed)
+		return nil
+	}
+
+	resp, err := cc.circle.RerunWorkflow(workflow.ID, opts)
+	if err != nil {
+		return fmt.Errorf("Error rerunning workflow %s, error: %s", workflow.Name, err)
+	}
+
+	// older API versions accept the rerun without saying which workflow it created
+	if resp.WorkflowID == "" {
+		cc.logger.Warn("CircleCI didn't return the rerun workflow, it won't be followed", logging.WorkflowID, workflow.ID)
+		return nil
+	}
+
+	return cc.follow(resp.WorkflowID)
+}
+
+func (cc *commandContext) rerunFailed() ([]string, error) {
+	rerun := []string{}
+	for _, w := range cc.workflows {
+		if w.Workflow.Status != "failed" && w.Workflow.Status != "error" {
+			continue
+		}
+
+		err := cc.rerun(w.Workflow, circleci.RerunWorkflowOptions{FromFailed: true})
+		if err != nil {
+			return nil, err
+		}
+		rerun = append(rerun, "`"+w.Workflow.Name+"`")
+	}
+
+	if len(rerun) == 0 {
+		return []string{"there are no failed workflows to rerun"}, nil
+	}
+
+	return []string{cc.did("rerunning", "rerun") + " the failed jobs of " + strings.Join(rerun, ", ")}, nil
+}
+
+func (cc *commandContext) rerunJob(name string) ([]string, error) {
+	found := cc.findJob(name)
+	if len(found) == 0 {
+		return []string{fmt.Sprintf("there is no job named `%s` in the pipeline for %s", name, cc.sha)}, nil
+	}
+
+	for _, w := range found {
+		err := cc.rerun(w.Workflow, circleci.RerunWorkflowOptions{Jobs: []string{w.Jobs[0].ID}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []string{fmt.Sprintf("%s `%s`", cc.did("rerunning", "rerun"), name)}, nil
+}
+
+func (cc *commandContext) logs(name string) ([]string, error) {
+	found := cc.findJob(name)
+	if len(found) == 0 {
+		return []string{fmt.Sprintf("there is no job named `%s` in the pipeline for %s", name, cc.sha)}, nil
+	}
+
+	replies := []string{}
+	for _, w := range found {
+		job := w.Jobs[0]
+		if job.Status != "failed" {
+			replies = append(replies, fmt.Sprintf("`%s` in `%s` is %s, logs are only posted for failed jobs", name, w.Workflow.Name, job.Status))
+			continue
+		}
+
+		outputs, err := feedback.FailedOutputs(cc.ctx, cc.circle, cc.config.CircleVCS, cc.owner, cc.repo, []int{job.JobNumber}, cc.config.CircleConcurrency)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, output := range outputs[0] {
+			replies = append(replies, feedback.LogComments(output.Label(name), output.Output)...)
+		}
+	}
+
+	return replies, nil
+}
+
+func (cc *commandContext) cancel() ([]string, error) {
+	canceled := []string{}
+	for _, w := range cc.workflows {
+		if w.Workflow.Status != "running" && w.Workflow.Status != "failing" && w.Workflow.Status != "on_hold" {
+			continue
+		}
+
+		if cc.dryRun {
+			cc.logger.Info("Dry run, not canceling a workflow", logging.WorkflowID, w.Workflow.ID)
+		} else {
+			err := cc.circle.CancelWorkflow(w.Workflow.ID)
+			if err != nil {
+				return nil, fmt.Errorf("Error canceling workflow %s, error: %s", w.Workflow.Name, err)
+			}
+		}
+		canceled = append(canceled, "`"+w.Workflow.Name+"`")
+	}
+
+	if len(canceled) == 0 {
+		return []string{"there are no running workflows to cancel"}, nil
+	}
+
+	return []string{cc.did("canceled", "cancel") + " " + strings.Join(canceled, ", ")}, nil
+}
+
+// did starts the reply to a command that changes something on CircleCI, in dry run nothing was changed and the
+// reply says what would have been
+func (cc *commandContext) did(done, would string) string {
+	if cc.dryRun {
+		return "this repo is in dry run, I would " + would
+	}
+
+	return done
+}
@@ -0,0 +1,147 @@
+// Package entry handles the GitHub webhooks of the app, new commits on pull requests start a step function
+// execution that watches their pipeline and /circleci commands in comments act on the pipeline
+package entry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/codingdiaz/circleci-feedback/internal/dedup"
+	"github.com/codingdiaz/circleci-feedback/internal/dryrun"
+	"github.com/codingdiaz/circleci-feedback/internal/logging"
+	"github.com/codingdiaz/circleci-feedback/internal/metrics"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/tracing"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+)
+
+// Handler handles webhooks, it is kept across warm invocations so the deliveries it remembers in memory
+// and the installation tokens of its GitHub clients are reused
+// The main of the entry function wires in the real services, tests use fakes
+type Handler struct {
+	Deliveries  dedup.Store   // the webhook deliveries that were already handled
+	DeliveryTTL time.Duration // how long deliveries are remembered, defaults to dedup.DefaultTTL
+
+	Config     stepfunc.ConfigSource   // read for every webhook
+	GitHub     githubapp.Installations // comments on pull requests and reads repos
+	CircleCI   stepfunc.CircleCIClients
+	Executions ExecutionStarter // starts the step function executions that watch pipelines
+	DryRuns    dryrun.Store     // keeps the comments on repos in dry run, they are only logged when nil
+}
+
+func (h *Handler) deliveryTTL() time.Duration {
+	if h.DeliveryTTL == 0 {
+		return dedup.DefaultTTL
+	}
+
+	return h.DeliveryTTL
+}
+
+// Handle handles a webhook in the first span of its trace and counts it by event and response status
+func (h *Handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	defer metrics.Flush()
+	defer tracing.Flush()
+
+	// a traceparent sent with the request is only followed once the request is validated, see handle
+	ctx, span := tracing.Start(ctx, "webhook", tracing.Server)
+	span.SetAttribute(logging.CorrelationID, request.Headers["X-GitHub-Delivery"])
+
+	response, err := h.handle(ctx, request)
+
+	// the event header of a request that failed validation could be anything
+	event := request.Headers["X-GitHub-Event"]
+	if response.StatusCode == 403 {
+		event = "unverified"
+	}
+	metrics.WebhooksReceived.Inc(event, strconv.Itoa(response.StatusCode))
+
+	span.SetAttribute("github.event", event)
+	span.SetAttribute("http.response.status_code", response.StatusCode)
+	spanErr := err
+	if spanErr == nil && response.StatusCode >= 500 {
+		spanErr = fmt.Errorf("Handling the webhook failed with %d", response.StatusCode)
+	}
+	span.End(spanErr)
+
+	return response, err
+}
+
+func (h *Handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// the delivery ID follows the webhook through the step function execution it starts
+	eventType := request.Headers["X-GitHub-Event"]
+	logger := slog.With(logging.CorrelationID, request.Headers["X-GitHub-Delivery"], "event", eventType)
+
+	// get configuration for lambda function to run
+	c, err := h.Config.Config()
+	if err != nil {
+		logger.Error("Error getting lambda function configuration", "error", err)
+		return events.APIGatewayProxyResponse{Body: request.Body, StatusCode: 500}, nil
+	}
+
+	// GitHub Enterprise Server webhooks name their host, only accept webhooks from the host this deployment serves
+	if host := githubapp.WebhookHost(request); host != c.GitHubHost {
+		logger.Warn("Received a webhook from a host this deployment doesn't serve", "host", host, "served_host", c.GitHubHost)
+		return events.APIGatewayProxyResponse{StatusCode: 403}, nil
+	}
+
+	// validate the request and return unauthorized if the validate function returns an error
+	validator := githubapp.Validator{
+		Secrets:   []string{c.GitHubWebhookSecret, c.GitHubWebhookSecretPrevious},
+		AllowSHA1: c.AllowSHA1Signatures,
+	}
+	err = validator.Validate(request)
+	if err != nil {
+		logger.Warn("The request was not valid", "error", err)
+		return events.APIGatewayProxyResponse{StatusCode: 403}, nil
+	}
+
+	// a traceparent sent with a valid request, by a proxy in front of the server, makes the webhook part of its trace
+	// anyone can send one with a request that isn't, it would put their trace IDs in our spans
+	if span := tracing.FromContext(ctx); span != nil {
+		span.Continue(request.Headers["traceparent"])
+	}
+
+	// only trigger on certain events that come in a header from github
+	// if the event type is outside of what we want to process, return
+	var handle func(context.Context, events.APIGatewayProxyRequest, stepfunc.Config, *slog.Logger) events.APIGatewayProxyResponse
+	switch eventType {
+	case "pull_request":
+		handle = h.handlePullRequest
+	case "issue_comment":
+		handle = h.handleIssueComment
+	default:
+		logger.Info("Request eventType is not supported")
+		return events.APIGatewayProxyResponse{Body: request.Body, StatusCode: 200}, nil
+	}
+
+	// GitHub redelivers webhooks and API Gateway retries requests, only handle each delivery once
+	deliveryKey := "delivery/" + request.Headers["X-GitHub-Delivery"]
+	if request.Headers["X-GitHub-Delivery"] != "" {
+		claimed, err := h.Deliveries.Claim(deliveryKey, h.deliveryTTL())
+		if err != nil {
+			logger.Error("Error checking if the delivery was already handled", "error", err)
+			return events.APIGatewayProxyResponse{StatusCode: 500}, nil
+		}
+		if !claimed {
+			logger.Info("The delivery was already handled, skipping it")
+			return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+		}
+	}
+
+	response := handle(ctx, request, c, logger)
+
+	// let a retry of this delivery try again when we failed to handle it
+	if response.StatusCode >= 500 && request.Headers["X-GitHub-Delivery"] != "" {
+		err = h.Deliveries.Release(deliveryKey)
+		if err != nil {
+			logger.Error("Error releasing the delivery", "error", err)
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,273 @@
+package entry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/codingdiaz/circleci-feedback/internal/dedup"
+	"github.com/codingdiaz/circleci-feedback/internal/dryrun"
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakegithub"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakes"
+	"github.com/codingdiaz/circleci-feedback/internal/tracing"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+	"github.com/google/go-github/github"
+)
+
+const (
+	secret = "webhook-secret"
+	sha    = "0123456789abcdef0123456789abcdef01234567"
+)
+
+func newHandler() (*Handler, *fakes.GitHub, *fakes.CircleCI, *fakes.Executions) {
+	gh := &fakes.GitHub{
+		Files:        map[string]string{"octo/app/.circleci/config.yml": "version: 2.1\n"},
+		Permissions:  map[string]string{"octo/app/mona": "write"},
+		PullRequests: map[string]*github.PullRequest{},
+	}
+	circle := &fakes.CircleCI{}
+	executions := &fakes.Executions{}
+
+	h := &Handler{
+		Deliveries: dedup.NewMemoryStore(),
+		Config:     stepfunc.StaticConfig{GitHubWebhookSecret: secret, GitHubHost: "github.com", CircleVCS: "gh", CircleConcurrency: 1},
+		GitHub:     gh,
+		CircleCI:   circle,
+		Executions: executions,
+	}
+	return h, gh, circle, executions
+}
+
+func pullRequestEvent(action string) map[string]interface{} {
+	return map[string]interface{}{
+		"action":       action,
+		"number":       7,
+		"installation": map[string]interface{}{"id": 42},
+		"repository":   map[string]interface{}{"name": "app", "owner": map[string]interface{}{"login": "octo"}},
+		"pull_request": map[string]interface{}{
+			"user": map[string]interface{}{"login": "mona"},
+			"head": map[string]interface{}{"ref": "feature", "sha": sha},
+			"base": map[string]interface{}{"ref": "main"},
+		},
+	}
+}
+
+func commentEvent(login, body string) map[string]interface{} {
+	return map[string]interface{}{
+		"action":       "created",
+		"installation": map[string]interface{}{"id": 42},
+		"repository":   map[string]interface{}{"name": "app", "owner": map[string]interface{}{"login": "octo"}},
+		"issue":        map[string]interface{}{"number": 7, "pull_request": map[string]interface{}{}},
+		"comment":      map[string]interface{}{"body": body},
+		"sender":       map[string]interface{}{"login": login, "type": "User"},
+	}
+}
+
+func TestPullRequestStartsExecution(t *testing.T) {
+	h, gh, _, executions := newHandler()
+
+	response, err := h.Handle(context.Background(), fakegithub.Webhook(t, "pull_request", "delivery-1", secret, pullRequestEvent("synchronize")))
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("Expected 200, got %d, error: %v", response.StatusCode, err)
+	}
+
+	started := executions.Started()
+	if len(started) != 1 {
+		t.Fatalf("Expected 1 execution, got %d", len(started))
+	}
+	in := started[0].Input
+	if in.Owner != "octo" || in.RepoName != "app" || in.PullRequestNumber != 7 || in.CommitSHA != sha || in.Branch != "feature" ||
+		in.BaseBranch != "main" || in.Author != "mona" || in.InstallationID != 42 || in.CorrelationID != "delivery-1" {
+		t.Errorf("The execution input doesn't match the pull request: %+v", in)
+	}
+	if started[0].Name != executionName(in) {
+		t.Errorf("Expected the execution to be named %s, got %s", executionName(in), started[0].Name)
+	}
+	if len(gh.Comments()) != 0 {
+		t.Errorf("Expected no comments, got %+v", gh.Comments())
+	}
+}
+
+func TestPullRequestIgnoredActions(t *testing.T) {
+	h, _, _, executions := newHandler()
+
+	response, _ := h.Handle(context.Background(), fakegithub.Webhook(t, "pull_request", "delivery-1", secret, pullRequestEvent("closed")))
+	if response.StatusCode != 200 || len(executions.Started()) != 0 {
+		t.Errorf("Expected a closed pull request to be ignored, got %d and %d executions", response.StatusCode, len(executions.Started()))
+	}
+}
+
+func TestPullRequestWithoutConfig(t *testing.T) {
+	h, gh, _, _ := newHandler()
+	gh.Files = map[string]string{}
+
+	response, _ := h.Handle(context.Background(), fakegithub.Webhook(t, "pull_request", "delivery-1", secret, pullRequestEvent("opened")))
+	if response.StatusCode != 200 {
+		t.Fatalf("Expected 200, got %d", response.StatusCode)
+	}
+
+	comments := gh.Comments()
+	if len(comments) != 1 || comments[0].Number != 7 {
+		t.Fatalf("Expected a comment on the pull request, got %+v", comments)
+	}
+}
+
+func TestUnsignedWebhook(t *testing.T) {
+	h, _, _, executions := newHandler()
+
+	response, _ := h.Handle(context.Background(), fakegithub.Webhook(t, "pull_request", "delivery-1", "another-secret", pullRequestEvent("opened")))
+	if response.StatusCode != 403 {
+		t.Errorf("Expected 403, got %d", response.StatusCode)
+	}
+	if len(executions.Started()) != 0 {
+		t.Errorf("Expected no executions, got %d", len(executions.Started()))
+	}
+}
+
+const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func TestTraceParentOfAValidWebhook(t *testing.T) {
+	h, _, _, executions := newHandler()
+
+	request := fakegithub.Webhook(t, "pull_request", "delivery-1", secret, pullRequestEvent("opened"))
+	request.Headers["traceparent"] = traceparent
+	h.Handle(context.Background(), request)
+
+	started := executions.Started()
+	if len(started) != 1 || !strings.HasPrefix(started[0].Input.TraceParent, "00-0af7651916cd43dd8448eb211c80319c-") {
+		t.Errorf("Expected the execution to continue the trace of the traceparent, got %+v", started)
+	}
+}
+
+func TestTraceParentOfAnUnsignedWebhook(t *testing.T) {
+	h, _, _, _ := newHandler()
+
+	traces := make(chan string, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		traces <- string(b)
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	tracing.Setup()
+	defer func() {
+		os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		tracing.Setup()
+	}()
+
+	request := fakegithub.Webhook(t, "pull_request", "delivery-1", "another-secret", pullRequestEvent("opened"))
+	request.Headers["traceparent"] = traceparent
+	h.Handle(context.Background(), request)
+
+	exported := <-traces
+	if !strings.Contains(exported, `"name":"webhook"`) || strings.Contains(exported, "0af7651916cd43dd8448eb211c80319c") {
+		t.Errorf("Expected the webhook span to start a trace of its own, got %s", exported)
+	}
+}
+
+func TestFailedExecutionIsRetried(t *testing.T) {
+	h, _, _, executions := newHandler()
+	executions.Err = &circleci.APIError{HTTPStatusCode: 500, Message: "unavailable"}
+
+	request := fakegithub.Webhook(t, "pull_request", "delivery-1", secret, pullRequestEvent("opened"))
+	response, _ := h.Handle(context.Background(), request)
+	if response.StatusCode != 500 {
+		t.Fatalf("Expected 500, got %d", response.StatusCode)
+	}
+
+	executions.Err = nil
+	response, _ = h.Handle(context.Background(), request)
+	if response.StatusCode != 200 || len(executions.Started()) != 1 {
+		t.Errorf("Expected the retry to start the execution, got %d and %d executions", response.StatusCode, len(executions.Started()))
+	}
+}
+
+func TestCancelCommand(t *testing.T) {
+	h, gh, circle, _ := newHandler()
+	gh.PullRequests["octo/app/7"] = &github.PullRequest{
+		Head: &github.PullRequestBranch{Ref: github.String("feature"), SHA: github.String(sha)},
+		Base: &github.PullRequestBranch{Ref: github.String("main")},
+		User: &github.User{Login: github.String("mona")},
+	}
+	circle.Pipelines = []circleci.Pipeline{fakes.Pipeline("pipeline-1", "gh/octo/app", "feature", sha, "workflow-1", "workflow-2")}
+	circle.Workflows = map[string]*circleci.Workflow{
+		"workflow-1": {ID: "workflow-1", Name: "build", Status: "running"},
+		"workflow-2": {ID: "workflow-2", Name: "deploy", Status: "success"},
+	}
+	circle.Jobs = map[string][]circleci.Job{"workflow-1": {}, "workflow-2": {}}
+
+	response, _ := h.Handle(context.Background(), fakegithub.Webhook(t, "issue_comment", "delivery-1", secret, commentEvent("mona", "/circleci cancel")))
+	if response.StatusCode != 200 {
+		t.Fatalf("Expected 200, got %d", response.StatusCode)
+	}
+
+	if len(circle.Canceled) != 1 || circle.Canceled[0] != "workflow-1" {
+		t.Errorf("Expected workflow-1 to be canceled, got %v", circle.Canceled)
+	}
+	comments := gh.Comments()
+	if len(comments) != 1 || comments[0].Body != "@mona canceled `build`" {
+		t.Errorf("Expected a reply saying build was canceled, got %+v", comments)
+	}
+}
+
+func TestCommandsInDryRun(t *testing.T) {
+	h, gh, circle, executions := newHandler()
+	dryRun := true
+	h.Config = stepfunc.StaticConfig{GitHubWebhookSecret: secret, GitHubHost: "github.com", CircleVCS: "gh", CircleConcurrency: 1, Repos: &repoconfig.File{
+		Repos: map[string]repoconfig.Settings{"octo/app": {DryRun: &dryRun}},
+	}}
+	store := dryrun.NewMemoryStore()
+	h.DryRuns = store
+	gh.PullRequests["octo/app/7"] = &github.PullRequest{
+		Head: &github.PullRequestBranch{Ref: github.String("feature"), SHA: github.String(sha)},
+		Base: &github.PullRequestBranch{Ref: github.String("main")},
+		User: &github.User{Login: github.String("mona")},
+	}
+	circle.Pipelines = []circleci.Pipeline{fakes.Pipeline("pipeline-1", "gh/octo/app", "feature", sha, "workflow-1", "workflow-2")}
+	circle.Workflows = map[string]*circleci.Workflow{
+		"workflow-1": {ID: "workflow-1", Name: "build", Status: "running"},
+		"workflow-2": {ID: "workflow-2", Name: "deploy", Status: "failed"},
+	}
+	circle.Jobs = map[string][]circleci.Job{"workflow-1": {}, "workflow-2": {}}
+
+	h.Handle(context.Background(), fakegithub.Webhook(t, "issue_comment", "delivery-1", secret, commentEvent("mona", "/circleci cancel\n/circleci rerun failed")))
+
+	if len(circle.Canceled) != 0 || len(circle.Reruns) != 0 || len(executions.Started()) != 0 {
+		t.Errorf("Expected nothing to change on CircleCI in dry run, got cancels %v, reruns %v and %d executions", circle.Canceled, circle.Reruns, len(executions.Started()))
+	}
+	if len(gh.Comments()) != 0 {
+		t.Errorf("Expected no replies to be posted in dry run, got %+v", gh.Comments())
+	}
+	kept, err := store.Recent("octo/app", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := []string{}
+	for _, c := range kept {
+		bodies = append(bodies, c.Body)
+	}
+	if !strings.Contains(strings.Join(bodies, "\n"), "@mona this repo is in dry run, I would cancel `build`") ||
+		!strings.Contains(strings.Join(bodies, "\n"), "@mona this repo is in dry run, I would rerun the failed jobs of `deploy`") {
+		t.Errorf("Expected the kept replies to say what would be done, got %q", bodies)
+	}
+}
+
+func TestCommandNeedsWriteAccess(t *testing.T) {
+	h, gh, circle, _ := newHandler()
+
+	h.Handle(context.Background(), fakegithub.Webhook(t, "issue_comment", "delivery-1", secret, commentEvent("stranger", "/circleci cancel")))
+
+	if len(circle.Canceled) != 0 {
+		t.Errorf("Expected nothing to be canceled, got %v", circle.Canceled)
+	}
+	comments := gh.Comments()
+	if len(comments) != 1 || comments[0].Body != "@stranger you need write access to this repository to run `/circleci` commands" {
+		t.Errorf("Expected a reply saying write access is needed, got %+v", comments)
+	}
+}
@@ -0,0 +1,77 @@
+package entry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/codingdiaz/circleci-feedback/internal/metrics"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+)
+
+// ExecutionStarter starts the step function executions that watch pipelines
+type ExecutionStarter interface {
+	// StartExecution starts an execution named name with input, an execution that already exists
+	// with the same name is not an error
+	StartExecution(input stepfunc.Data, name string) error
+}
+
+// StepFunctions starts executions of an AWS Step Functions state machine
+type StepFunctions struct {
+	StateMachineARN string // the STEP_FUNCTION_ARN of the entry function
+}
+
+// StartExecution starts an execution of the state machine with input, an execution that already exists
+// with the same name is not an error
+func (s StepFunctions) StartExecution(input stepfunc.Data, name string) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return fmt.Errorf("Error creating aws session, error: %s", err)
+	}
+	svc := sfn.New(sess, aws.NewConfig())
+
+	data, _ := json.Marshal(input)
+
+	sfnExecutionInput := &sfn.StartExecutionInput{
+		StateMachineArn: aws.String(s.StateMachineARN),
+		Name:            aws.String(name),
+		Input:           aws.String(string(data)),
+	}
+	_, err = svc.StartExecution(sfnExecutionInput)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == sfn.ErrCodeExecutionAlreadyExists {
+		input.Logger().Info("An execution with the same name already exists, skipping it", "execution", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// executions started by a command already know the workflows they follow
+	trigger := "pull_request"
+	if len(input.WorkflowIDs) > 0 {
+		trigger = "command"
+	}
+	metrics.ExecutionsStarted.Inc(trigger)
+
+	return nil
+}
+
+// executionName names the step function execution after the commit being watched,
+// step functions rejects a second execution with the same name so this also deduplicates
+// different deliveries for the same commit, names are limited to 80 characters
+func executionName(in stepfunc.Data) string {
+	repo := sha256.Sum256([]byte(in.Owner + "/" + in.RepoName))
+	return fmt.Sprintf("%x-%d-%s", repo[:4], in.PullRequestNumber, in.CommitSHA)
+}
+
+// rerunExecutionName names the execution that follows a rerun workflow of the commit
+func rerunExecutionName(in stepfunc.Data, workflowID string) string {
+	if len(workflowID) > 8 {
+		workflowID = workflowID[:8]
+	}
+	return executionName(in) + "-" + workflowID
+}
@@ -0,0 +1,96 @@
+package entry
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/codingdiaz/circleci-feedback/internal/dryrun"
+	"github.com/codingdiaz/circleci-feedback/internal/logging"
+	"github.com/codingdiaz/circleci-feedback/internal/metrics"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/tracing"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+	githubEvents "gopkg.in/go-playground/webhooks.v5/github"
+)
+
+// handlePullRequest starts watching the builds of new commits on pull requests
+func (h *Handler) handlePullRequest(ctx context.Context, request events.APIGatewayProxyRequest, c stepfunc.Config, logger *slog.Logger) events.APIGatewayProxyResponse {
+
+	// unmarshal request body into go struct
+	event := githubEvents.PullRequestPayload{}
+	err := json.Unmarshal([]byte(request.Body), &event)
+	if err != nil {
+		logger.Error("Unable to unmarshal request body into go struct", "error", err)
+		return events.APIGatewayProxyResponse{Body: request.Body, StatusCode: 200}
+	}
+
+	logger = logger.With(
+		logging.Owner, event.Repository.Owner.Login,
+		logging.Repo, event.Repository.Name,
+		logging.PullRequest, event.Number,
+		logging.SHA, event.PullRequest.Head.Sha,
+	)
+
+	// only process syncronize events (new commits on a pr) and the opened PR event
+	// https://developer.github.com/v3/activity/events/types/#pullrequestevent
+	if event.Action != "synchronize" && event.Action != "opened" {
+		return events.APIGatewayProxyResponse{StatusCode: 200}
+	}
+
+	return h.startFeedback(ctx, request, c, event, logger)
+}
+
+// startFeedback checks the repo uses CircleCI and starts the step function for the pull request
+func (h *Handler) startFeedback(ctx context.Context, request events.APIGatewayProxyRequest, c stepfunc.Config, event githubEvents.PullRequestPayload, logger *slog.Logger) events.APIGatewayProxyResponse {
+
+	// Create an autorized GitHub client
+	githubClient, err := h.GitHub.Installation(event.Installation.ID)
+	if err != nil {
+		logger.Error("Unable to create authenticated github client", "error", err)
+		return events.APIGatewayProxyResponse{Body: request.Body, StatusCode: 500}
+	}
+	settings := c.Repos.For(event.Installation.ID, event.Repository.Owner.Login+"/"+event.Repository.Name)
+	githubClient = dryrun.Wrap(githubClient, settings, h.DryRuns, event.Installation.ID, logger)
+
+	// Check to see if the repo has a file at `.circleci/config.yml`
+	_, err = githubClient.GetFile(ctx, event.Repository.Owner.Login, event.Repository.Name, ".circleci/config.yml", event.PullRequest.Head.Ref)
+
+	// if the repo doesn't have a `.circleci/config.yml file`, simply comment on the PR and return
+	if err != nil {
+		if err == githubapp.ErrNotFound {
+			_, err = githubClient.CreateComment(ctx, event.Repository.Owner.Login, event.Repository.Name, int(event.Number), "You don't seem to have a .circleci/config.yml file in your repo\n Register with CircleCI to use this GITHUB APP.")
+			if err != nil {
+				logger.Error("Unable to post a comment on the PR telling the user they don't have a circleci file", "error", err)
+				return events.APIGatewayProxyResponse{Body: request.Body, StatusCode: 500}
+			}
+			metrics.CommentsPosted.Inc("missing_config", "create")
+		} else {
+			logger.Error("Got an error trying to see if the repo has a circleci/config.yml file", "error", err)
+			return events.APIGatewayProxyResponse{Body: request.Body, StatusCode: 500}
+		}
+	}
+
+	// If the repo has a `.circleci/config.yml` file, start the step function
+	input := stepfunc.Data{
+		CorrelationID:     request.Headers["X-GitHub-Delivery"],
+		GitHubHost:        c.GitHubHost,
+		InstallationID:    int(event.Installation.ID),
+		CommitSHA:         event.PullRequest.Head.Sha,
+		Branch:            event.PullRequest.Head.Ref,
+		BaseBranch:        event.PullRequest.Base.Ref,
+		RepoName:          event.Repository.Name,
+		Owner:             event.Repository.Owner.Login,
+		PullRequestNumber: int(event.Number),
+		Author:            event.PullRequest.User.Login,
+		TraceParent:       tracing.TraceParent(ctx),
+	}
+
+	err = h.Executions.StartExecution(input, executionName(input))
+	if err != nil {
+		logger.Error("Error starting step function", "error", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500}
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200}
+}
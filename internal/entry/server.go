@@ -0,0 +1,54 @@
+package entry
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxWebhookSize is the largest payload GitHub sends, 25 MB
+const maxWebhookSize = 25 << 20
+
+// webhookHeaders are the headers the handler reads, API Gateway passes them with the case they are sent in
+var webhookHeaders = []string{
+	"X-GitHub-Event",
+	"X-GitHub-Delivery",
+	"X-GitHub-Enterprise-Host",
+	"X-Hub-Signature",
+	"X-Hub-Signature-256",
+	"traceparent",
+}
+
+// ServeHTTP takes webhooks POSTed to it like the API Gateway endpoint does, for running outside of Lambda
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		http.Error(w, "Error reading the request body", http.StatusBadRequest)
+		return
+	}
+
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod: r.Method,
+		Path:       r.URL.Path,
+		Headers:    map[string]string{},
+		Body:       string(body),
+	}
+	for _, name := range webhookHeaders {
+		if v := r.Header.Get(name); v != "" {
+			request.Headers[name] = v
+		}
+	}
+
+	response, _ := h.Handle(r.Context(), request)
+	for name, v := range response.Headers {
+		w.Header().Set(name, v)
+	}
+	w.WriteHeader(response.StatusCode)
+	io.WriteString(w, response.Body)
+}
@@ -0,0 +1,143 @@
+package feedback
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codingdiaz/circleci-feedback/internal/flaky"
+	"github.com/codingdiaz/circleci-feedback/internal/pool"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+// basePipelinesChecked is how many of the newest pipelines of a branch are looked at to find a finished one
+const basePipelinesChecked = 5
+
+// BranchStatus is what failed in the most recent finished pipeline of a branch
+type BranchStatus struct {
+	Branch   string
+	Pipeline circleci.Pipeline
+	URL      string
+	// Outcomes of the jobs and tests of the pipeline, for the flaky history
+	Outcomes []flaky.Outcome
+
+	failed map[string]bool // flaky.JobName and flaky.TestName of everything that failed
+}
+
+// LatestBranchStatus finds the newest pipeline on branch whose workflows are all done and what failed in it,
+// host is the CircleCI host the link to the pipeline points at
+// It returns nil when none of the recent pipelines of the branch are done, up to workers requests are made at a time
+// and canceling ctx cancels them
+func LatestBranchStatus(ctx context.Context, client circleci.API, host, vcs, owner, repo, branch string, workers int) (*BranchStatus, error) {
+	// only the newest few pipelines are checked so the first page is enough
+	pipelines, err := client.WithContext(ctx).FindPipelines(vcs, owner, repo, circleci.FindPipelinesOptions{Branch: branch, MaxPages: 1})
+	if err != nil {
+		return nil, fmt.Errorf("Error getting pipelines of branch %s, error: %s", branch, err)
+	}
+
+	for i, pipeline := range pipelines {
+		if i == basePipelinesChecked {
+			break
+		}
+
+		// the api filters by branch, but the filter is ignored for some project types
+		if pipeline.Vcs.Branch != branch {
+			continue
+		}
+
+		workflows, err := PipelineWorkflows(ctx, client, &pipeline, workers)
+		if err != nil {
+			return nil, err
+		}
+		if len(workflows) == 0 || !workflowsDone(workflows) {
+			continue
+		}
+
+		status := &BranchStatus{
+			Branch:   branch,
+			Pipeline: pipeline,
+			URL:      PipelineURL(host, vcs, owner, repo, pipeline.Number),
+			failed:   map[string]bool{},
+		}
+
+		// only failed jobs need their tests, they are what the pull request is compared with
+		finished := []WorkflowJobs{}
+		for _, w := range workflows {
+			for _, job := range w.Jobs {
+				if job.Status == "success" || job.Status == "failed" {
+					finished = append(finished, WorkflowJobs{Workflow: w.Workflow, Jobs: []circleci.Job{job}})
+				}
+			}
+		}
+
+		tests := make([][]circleci.TestResult, len(finished))
+		err = pool.Run(ctx, workers, len(finished), func(ctx context.Context, i int) error {
+			job := finished[i].Jobs[0]
+			if job.Status != "failed" || job.Type != "build" {
+				return nil
+			}
+
+			t, err := client.WithContext(ctx).GetJobTests(vcs, owner, repo, job.JobNumber)
+			if err != nil {
+				return fmt.Errorf("Error getting test results of job %v, error: %s", job.JobNumber, err)
+			}
+			tests[i] = t
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i, w := range finished {
+			job := w.Jobs[0]
+			if job.Status == "failed" {
+				status.failed[flaky.JobName(w.Workflow.Name, job.Name)] = true
+				for _, t := range tests[i] {
+					if t.Result == "failure" {
+						status.failed[flaky.TestName(t.Classname, t.Name)] = true
+					}
+				}
+			}
+
+			status.Outcomes = append(status.Outcomes, Outcomes(owner+"/"+repo, branch, pipeline.Vcs.Revision, w.Workflow.Name, job, tests[i])...)
+		}
+
+		return status, nil
+	}
+
+	return nil, nil
+}
+
+func workflowsDone(workflows []WorkflowJobs) bool {
+	for _, w := range workflows {
+		if !circleci.WorkflowDone(w.Jobs) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MarkAlreadyFailing notes on the failure which of the job and its tests also failed on the branch
+func (b *BranchStatus) MarkAlreadyFailing(f *Failure) {
+	note := fmt.Sprintf("already failing on %s ([pipeline %d](%s))", b.Branch, b.Pipeline.Number, b.URL)
+
+	if b.failed[flaky.JobName(f.Workflow, f.Job.Name)] {
+		f.Notes = append(f.Notes, note)
+	}
+
+	for i, t := range f.Tests {
+		if b.failed[flaky.TestName(t.Classname, t.Name)] {
+			f.Tests[i].Notes = append(f.Tests[i].Notes, note)
+		}
+	}
+}
+
+// PipelineURL links to a pipeline in the CircleCI web app of host
+func PipelineURL(host, vcs, owner, repo string, number int) string {
+	provider := "github"
+	if vcs == circleci.VCSBitbucket {
+		provider = "bitbucket"
+	}
+
+	return fmt.Sprintf("%s/pipelines/%s/%s/%s/%d", circleci.AppURL(host), provider, owner, repo, number)
+}
@@ -0,0 +1,87 @@
+package feedback
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakecircle"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+// basePipeline is a finished or running pipeline of octo/app whose test job ends with status
+func basePipeline(id string, number int, branch, status string) *fakecircle.Pipeline {
+	return &fakecircle.Pipeline{
+		ID:       id,
+		Slug:     "gh/octo/app",
+		Number:   number,
+		Branch:   branch,
+		Revision: id + "-sha",
+		Workflows: []*fakecircle.Workflow{{
+			ID:   id + "-workflow",
+			Name: "build",
+			Jobs: []*fakecircle.Job{
+				{ID: id + "-test", Name: "test", Number: number * 10, Statuses: []string{status}, Tests: []circleci.TestResult{
+					{Classname: "pkg", Name: "TestAdd", Result: "failure"},
+					{Classname: "pkg", Name: "TestSub", Result: "success"},
+				}},
+				{ID: id + "-lint", Name: "lint", Number: number*10 + 1, Statuses: []string{"success"}},
+			},
+		}},
+	}
+}
+
+func TestLatestBranchStatus(t *testing.T) {
+	server := fakecircle.NewServer(t)
+	server.AddPipeline(basePipeline("red-main", 1, "main", "failed"))
+	// a newer red pipeline of another branch isn't the status of main
+	server.AddPipeline(basePipeline("red-feature", 2, "feature", "failed"))
+	// the newest pipeline of main is still running, so it doesn't say whether main is red
+	server.AddPipeline(basePipeline("running-main", 3, "main", "running"))
+
+	client := &circleci.Client{BaseURL: circleci.BaseURL(server.URL)}
+	status, err := LatestBranchStatus(context.Background(), client, "circleci.example.com", "gh", "octo", "app", "main", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Pipeline.ID != "red-main" {
+		t.Fatalf("Expected the status of the finished pipeline of main, got %+v", status)
+	}
+	if status.URL != "https://circleci.example.com/pipelines/github/octo/app/1" {
+		t.Errorf("Expected a link to pipeline 1 on the CircleCI host, got %s", status.URL)
+	}
+
+	f := Failure{Workflow: "build", Job: circleci.Job{Name: "test"}, Tests: []FailedTest{
+		{TestResult: circleci.TestResult{Classname: "pkg", Name: "TestAdd"}},
+		{TestResult: circleci.TestResult{Classname: "pkg", Name: "TestSub"}},
+	}}
+	status.MarkAlreadyFailing(&f)
+
+	note := "already failing on main ([pipeline 1](https://circleci.example.com/pipelines/github/octo/app/1))"
+	if len(f.Notes) != 1 || f.Notes[0] != note {
+		t.Errorf("Expected the job to be noted as already failing, got %v", f.Notes)
+	}
+	if len(f.Tests[0].Notes) != 1 || f.Tests[0].Notes[0] != note {
+		t.Errorf("Expected TestAdd to be noted as already failing, got %v", f.Tests[0].Notes)
+	}
+	if len(f.Tests[1].Notes) != 0 {
+		t.Errorf("Expected TestSub, which passed on main, not to be noted, got %v", f.Tests[1].Notes)
+	}
+
+	lint := Failure{Workflow: "build", Job: circleci.Job{Name: "lint"}}
+	status.MarkAlreadyFailing(&lint)
+	if len(lint.Notes) != 0 {
+		t.Errorf("Expected lint, which passed on main, not to be noted, got %v", lint.Notes)
+	}
+}
+
+func TestLatestBranchStatusWithoutFinishedPipelines(t *testing.T) {
+	server := fakecircle.NewServer(t)
+	server.AddPipeline(basePipeline("red-feature", 1, "feature", "failed"))
+	server.AddPipeline(basePipeline("running-main", 2, "main", "running"))
+
+	client := &circleci.Client{BaseURL: circleci.BaseURL(server.URL)}
+	status, err := LatestBranchStatus(context.Background(), client, "circleci.example.com", "gh", "octo", "app", "main", 2)
+	if err != nil || status != nil {
+		t.Errorf("Expected no status while the only pipeline of main runs, got %+v, error: %v", status, err)
+	}
+}
@@ -0,0 +1,200 @@
+// Package feedback holds the CircleCI lookups and report formatting shared by the lambdas
+// that watch builds and the chat commands that act on them
+package feedback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codingdiaz/circleci-feedback/internal/pool"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+// ErrPipelineNotFound is returned when CircleCI has no pipeline for a commit (yet)
+var ErrPipelineNotFound = errors.New("Didn't find a pipeline id yet")
+
+// FindPipeline finds the newest pipeline that built sha, looking at the pipelines of branch first
+// Pull requests from forks are built on a pull/<number> branch so it falls back to every branch
+func FindPipeline(client circleci.API, vcs, owner, repo, branch, sha string) (*circleci.Pipeline, error) {
+	branches := []string{""}
+	if branch != "" {
+		branches = []string{branch, ""}
+	}
+
+	for _, b := range branches {
+		pipelines, err := client.FindPipelines(vcs, owner, repo, circleci.FindPipelinesOptions{Branch: b, Revision: sha})
+		if err != nil {
+			return nil, fmt.Errorf("Error getting pipelineIDs, error: %s", err)
+		}
+
+		if len(pipelines) > 0 {
+			return &pipelines[0], nil
+		}
+	}
+
+	return nil, ErrPipelineNotFound
+}
+
+// WorkflowJobs is a workflow together with its jobs
+type WorkflowJobs struct {
+	Workflow *circleci.Workflow
+	Jobs     []circleci.Job
+}
+
+// PipelineWorkflows gets every workflow of a pipeline with its jobs, in the order the pipeline lists them
+// Up to workers workflows are fetched at a time, canceling ctx cancels the requests
+func PipelineWorkflows(ctx context.Context, client circleci.API, pipeline *circleci.Pipeline, workers int) ([]WorkflowJobs, error) {
+	workflows := make([]WorkflowJobs, len(pipeline.Workflows))
+	err := pool.Run(ctx, workers, len(pipeline.Workflows), func(ctx context.Context, i int) error {
+		client := client.WithContext(ctx)
+		id := pipeline.Workflows[i].ID
+		workflow, err := client.GetWorkflow(id)
+		if err != nil {
+			return fmt.Errorf("Error getting workflow with id %v, error: %s", id, err)
+		}
+
+		jobs, err := client.GetWorkflowJobs(id)
+		if err != nil {
+			return fmt.Errorf("Error getting jobs for workflow with id %v, error: %s", id, err)
+		}
+
+		workflows[i] = WorkflowJobs{Workflow: workflow, Jobs: jobs}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return workflows, nil
+}
+
+// FailedOutput is the output of a failed step of a job
+// Jobs with parallelism run every step on each container, containers whose output is identical share one FailedOutput
+type FailedOutput struct {
+	Step        string
+	Containers  []int // indexes of the containers the output is from
+	Parallelism int   // how many containers the job ran on
+	Output      string
+}
+
+// Label names the job, and for jobs with parallelism the step and containers, the output is from
+func (o FailedOutput) Label(job string) string {
+	if o.Parallelism <= 1 {
+		return fmt.Sprintf("`%s`", job)
+	}
+
+	return fmt.Sprintf("`%s` step `%s` on %s", job, o.Step, ContainerList(o.Containers))
+}
+
+// ContainerList formats container indexes like "container 1" or "containers 0, 2 and 3"
+func ContainerList(containers []int) string {
+	if len(containers) == 1 {
+		return fmt.Sprintf("container %d", containers[0])
+	}
+
+	list := ""
+	for i, c := range containers {
+		switch {
+		case i == 0:
+		case i == len(containers)-1:
+			list = list + " and "
+		default:
+			list = list + ", "
+		}
+		list = list + fmt.Sprint(c)
+	}
+
+	return "containers " + list
+}
+
+// FailedOutputs returns the output of the failed steps of each job in jobNumbers, in the order of the steps
+// Up to workers step logs and outputs are fetched at a time, canceling ctx cancels the requests
+func FailedOutputs(ctx context.Context, client circleci.API, vcs, owner, repo string, jobNumbers []int, workers int) ([][]FailedOutput, error) {
+	jobs := make([]*circleci.JobLogs, len(jobNumbers))
+	err := pool.Run(ctx, workers, len(jobNumbers), func(ctx context.Context, i int) error {
+		logs, err := client.WithContext(ctx).StepLogs(circleci.JobRef{VCS: vcs, Account: owner, Repo: repo, Number: jobNumbers[i]})
+		if err != nil {
+			return fmt.Errorf("Error getting the steps of job %v %s", jobNumbers[i], err)
+		}
+
+		jobs[i] = logs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the failed steps of every job are downloaded together so one big job doesn't hold up the rest
+	type failedStep struct {
+		job int
+		log circleci.StepLog
+	}
+	failed := []failedStep{}
+	for i, logs := range jobs {
+		for _, log := range logs.Steps {
+			if log.Status == "failed" {
+				failed = append(failed, failedStep{job: i, log: log})
+			}
+		}
+	}
+
+	downloaded := make([]string, len(failed))
+	err = pool.Run(ctx, workers, len(failed), func(ctx context.Context, i int) error {
+		output, err := client.WithContext(ctx).StepOutput(failed[i].log)
+		if err != nil {
+			return fmt.Errorf("Error getting build output for failed build, %s", err)
+		}
+
+		downloaded[i] = output
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// containers of the same step that failed the same way are reported once
+	outputs := make([][]FailedOutput, len(jobNumbers))
+	for i, f := range failed {
+		merged := false
+		for j, o := range outputs[f.job] {
+			if o.Step == f.log.Step && o.Output == downloaded[i] {
+				outputs[f.job][j].Containers = append(outputs[f.job][j].Containers, f.log.Container)
+				merged = true
+				break
+			}
+		}
+		if merged {
+			continue
+		}
+
+		outputs[f.job] = append(outputs[f.job], FailedOutput{
+			Step:        f.log.Step,
+			Containers:  []int{f.log.Container},
+			Parallelism: jobs[f.job].Parallelism,
+			Output:      downloaded[i],
+		})
+	}
+
+	return outputs, nil
+}
+
+// LogComments formats a full output as one or more pull request comments, label is what it is the output of
+func LogComments(label, output string) []string {
+	comments := []string{}
+	for part := 1; ; part++ {
+		chunk := head(output, maxCommentLength)
+		output = output[len(chunk):]
+
+		header := "Output of " + label
+		if part > 1 || len(output) > 0 {
+			header = fmt.Sprintf("%s (part %d)", header, part)
+		}
+		comments = append(comments, header+"\n```\n"+strings.TrimRight(chunk, "\n")+"\n```")
+
+		if len(output) == 0 {
+			return comments
+		}
+	}
+}
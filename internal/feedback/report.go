@@ -0,0 +1,350 @@
+package feedback
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/codingdiaz/circleci-feedback/internal/codeowners"
+	"github.com/codingdiaz/circleci-feedback/internal/flaky"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+const (
+	// maxCommentLength keeps comments under the 65536 character limit GitHub has on comment bodies
+	maxCommentLength = 60000
+	// maxSectionOutput is how much output of each failed action the report shows,
+	// the report holds every failure of the pipeline and `/circleci logs` posts the rest
+	maxSectionOutput = 10000
+	// maxSectionOutputs is how much output all the failed steps of a job show together, a job with
+	// parallelism can fail differently on every container
+	maxSectionOutputs = 2 * maxSectionOutput
+	// maxSectionTests is how long the list of failed tests of a job gets before the rest are only counted
+	maxSectionTests = 5000
+	// minStepOutput is the least output worth showing for a step, less than that is left out
+	minStepOutput = 500
+
+	// the report comment is edited as jobs fail, these markers let it be parsed back into sections
+	reportMarker  = "<!-- circleci-feedback report -->"
+	sectionMarker = "<!-- circleci-feedback failure -->"
+
+	// maxLoginLength is the longest GitHub login, the report leaves room to mention anyone
+	maxLoginLength = 39
+)
+
+// Failure is a failed job and what is known about why it failed
+type Failure struct {
+	Workflow string
+	Job      circleci.Job
+	Notes    []string // shown next to the job name, for example why it is likely flaky
+	Tests    []FailedTest
+}
+
+// FailedTest is a failed test of a failed job
+type FailedTest struct {
+	circleci.TestResult
+	Notes  []string
+	Owners []string // code owners of the file of the test, mentioned in the report
+}
+
+// NewFailure returns the failure of job, tests are all the test results of the job
+func NewFailure(workflow string, job circleci.Job, tests []circleci.TestResult) Failure {
+	f := Failure{Workflow: workflow, Job: job}
+	for _, t := range tests {
+		if t.Result == "failure" {
+			f.Tests = append(f.Tests, FailedTest{TestResult: t})
+		}
+	}
+
+	return f
+}
+
+// Outcomes returns the outcome history of a finished job and its tests
+// Only jobs that succeeded or failed have one, a canceled or blocked job says nothing about flakiness
+func Outcomes(repo, branch, sha, workflow string, job circleci.Job, tests []circleci.TestResult) []flaky.Outcome {
+	if job.Status != "success" && job.Status != "failed" {
+		return nil
+	}
+
+	at := job.StopTime
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	outcomes := []flaky.Outcome{{
+		Repo:   repo,
+		Name:   flaky.JobName(workflow, job.Name),
+		Branch: branch,
+		SHA:    sha,
+		Failed: job.Status == "failed",
+		Time:   at,
+	}}
+
+	for _, t := range tests {
+		if t.Result != "success" && t.Result != "failure" {
+			continue
+		}
+
+		outcomes = append(outcomes, flaky.Outcome{
+			Repo:   repo,
+			Name:   flaky.TestName(t.Classname, t.Name),
+			Branch: branch,
+			SHA:    sha,
+			Failed: t.Result == "failure",
+			Time:   at,
+		})
+	}
+
+	return outcomes
+}
+
+// MarkFlaky notes on the failure which of the job and its tests fail often enough in their
+// recent history to likely be flaky, branch is the branch whose runs are trusted the most
+func MarkFlaky(history flaky.Store, repo, branch string, f *Failure) error {
+	note, err := flakyNote(history, repo, flaky.JobName(f.Workflow, f.Job.Name), branch)
+	if err != nil {
+		return err
+	}
+	if note != "" {
+		f.Notes = append(f.Notes, note)
+	}
+
+	for i, t := range f.Tests {
+		note, err := flakyNote(history, repo, flaky.TestName(t.Classname, t.Name), branch)
+		if err != nil {
+			return err
+		}
+		if note != "" {
+			f.Tests[i].Notes = append(f.Tests[i].Notes, note)
+		}
+	}
+
+	return nil
+}
+
+func flakyNote(history flaky.Store, repo, name, branch string) (string, error) {
+	recent, err := history.Recent(repo, name, flaky.RecentRuns)
+	if err != nil {
+		return "", fmt.Errorf("Error getting the history of %s, error: %s", name, err)
+	}
+
+	score := flaky.Assess(recent, branch)
+	if !score.Flaky() {
+		return "", nil
+	}
+
+	return fmt.Sprintf("likely flaky (%s)", score), nil
+}
+
+// Section formats the failure with the output of its failed steps as a section of the report
+// Long output is cut down to its end, that is where the failure usually is, and the list of tests and
+// the output of all the steps are capped so a section always fits in a comment with room to spare
+func (f Failure) Section(outputs []FailedOutput) string {
+	message := fmt.Sprintf("Build Failed :cry: `%s`", f.Job.Name)
+	if len(f.Notes) > 0 {
+		message = message + " _" + strings.Join(f.Notes, ", ") + "_"
+	}
+	message = message + "\n"
+
+	if len(f.Tests) > 0 {
+		message = message + "\nFailed tests:\n"
+		start := len(message)
+		for i, t := range f.Tests {
+			if len(message)-start > maxSectionTests {
+				message = message + fmt.Sprintf("* and %d more\n", len(f.Tests)-i)
+				break
+			}
+
+			notes := t.Notes
+			if containers := TestContainers(t.TestResult, outputs); len(containers) > 0 {
+				notes = append(notes[:len(notes):len(notes)], "ran on "+ContainerList(containers))
+			}
+
+			message = message + fmt.Sprintf("* `%s`", strings.TrimPrefix(flaky.TestName(t.Classname, t.Name), "test:"))
+			if len(notes) > 0 {
+				message = message + " _" + strings.Join(notes, ", ") + "_"
+			}
+			if len(t.Owners) > 0 {
+				message = message + " cc " + strings.Join(t.Owners, " ")
+			}
+			message = message + "\n"
+		}
+		message = message + "\n"
+	}
+
+	budget := maxSectionOutputs
+	for i, o := range outputs {
+		limit := maxSectionOutput
+		if budget < limit {
+			limit = budget
+		}
+		if limit < minStepOutput {
+			message = message + fmt.Sprintf("The output of %d more failed %s is left out, `/circleci logs %s` posts all of it\n",
+				len(outputs)-i, plural(len(outputs)-i, "step", "steps"), f.Job.Name)
+			break
+		}
+
+		if o.Parallelism > 1 {
+			message = message + fmt.Sprintf("Step `%s` failed on %s:\n", o.Step, ContainerList(o.Containers))
+		}
+
+		output := o.Output
+		if len(output) > limit {
+			output = "...\n" + tail(output, limit)
+		}
+		budget = budget - len(output)
+		message = message + "```\n" + output + "\n```\n"
+	}
+
+	return message
+}
+
+// TestContainers finds which containers of a job with parallelism ran a test
+// The test results don't say, but with test splitting a failed test shows up in the output of the container that ran it,
+// the name has to be a whole word of the output so TestAdd isn't found in the output of TestAddMore
+func TestContainers(t circleci.TestResult, outputs []FailedOutput) []int {
+	if t.Name == "" {
+		return nil
+	}
+
+	seen := map[int]bool{}
+	containers := []int{}
+	for _, o := range outputs {
+		if o.Parallelism <= 1 || !containsWord(o.Output, t.Name) {
+			continue
+		}
+		for _, c := range o.Containers {
+			if !seen[c] {
+				seen[c] = true
+				containers = append(containers, c)
+			}
+		}
+	}
+	sort.Ints(containers)
+
+	return containers
+}
+
+// tail is the end of s that fits in n bytes, starting on a line when a line break is in its first half
+// so the output doesn't start halfway through a line, and otherwise on a rune
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	start := len(s) - n
+	if i := strings.IndexByte(s[start:], '\n'); i >= 0 && i < n/2 {
+		return s[start+i+1:]
+	}
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return s[start:]
+}
+
+// head is the start of s that fits in n bytes, ending after a line when a line break is in its second half,
+// and otherwise on a rune
+func head(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if i := strings.LastIndexByte(s[:n], '\n'); i >= n/2 {
+		return s[:i+1]
+	}
+	end := n
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
+
+// containsWord says whether word is in s without a letter, digit or _ right before or after it
+func containsWord(s, word string) bool {
+	for start := 0; ; {
+		i := strings.Index(s[start:], word)
+		if i < 0 {
+			return false
+		}
+		i = start + i
+		end := i + len(word)
+
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if (i == 0 || !isWordRune(before)) && (end == len(s) || !isWordRune(after)) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Report formats the report comment of a pipeline from the sections of its failures, mentioning author when it is set
+// running means jobs are still not done and the comment will be updated as they finish
+func Report(sections []string, running bool, author string) string {
+	header := fmt.Sprintf("**CircleCI:** %d failed %s", len(sections), plural(len(sections), "job", "jobs"))
+	if author != "" {
+		header = "@" + author + " " + header
+	}
+	if running {
+		header = header + " so far, this comment is updated as the pipeline runs :hourglass:"
+	}
+
+	message := reportMarker + "\n" + header + "\n"
+	for _, section := range sections {
+		message = message + sectionMarker + "\n" + section
+	}
+
+	return message
+}
+
+// ReportSections returns the failure sections of a report comment made by Report
+func ReportSections(body string) []string {
+	parts := strings.Split(body, sectionMarker+"\n")
+	return parts[1:]
+}
+
+// FitsReport reports whether a report with sections fits in one comment
+func FitsReport(sections []string) bool {
+	return len(Report(sections, true, strings.Repeat("x", maxLoginLength))) <= maxCommentLength
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// MarkOwners sets the code owners of the file of each failed test of the failure
+func MarkOwners(owners *codeowners.File, f *Failure) {
+	for i, t := range f.Tests {
+		if t.File != "" {
+			f.Tests[i].Owners = owners.Owners(repoPath(t.File))
+		}
+	}
+}
+
+// repoPath makes the path of a test file relative to the root of the repo, test results
+// often have absolute paths under the default CircleCI working directory ~/project
+func repoPath(file string) string {
+	if i := strings.Index(file, "/project/"); i >= 0 && strings.HasPrefix(file, "/") {
+		return file[i+len("/project/"):]
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(file, "./"), "/")
+}
+
+// GaveUpComment tells the pull request the app stopped watching a pipeline that didn't finish, listing the jobs that weren't done
+func GaveUpComment(waited time.Duration, unfinished []string) string {
+	message := fmt.Sprintf("Stopped watching this pipeline after %d minutes :hourglass: these jobs were still not done:\n", int(waited.Minutes()))
+	for _, job := range unfinished {
+		message = message + "* " + job + "\n"
+	}
+
+	return message
+}
@@ -0,0 +1,145 @@
+package feedback
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/codingdiaz/circleci-feedback/internal/flaky"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+func TestSectionIsCapped(t *testing.T) {
+	f := Failure{Job: circleci.Job{Name: "test"}}
+	for i := 0; i < 5000; i++ {
+		f.Tests = append(f.Tests, FailedTest{TestResult: circleci.TestResult{Classname: "pkg", Name: fmt.Sprintf("TestCase%d", i)}})
+	}
+	outputs := []FailedOutput{}
+	for c := 0; c < 20; c++ {
+		outputs = append(outputs, FailedOutput{Step: "go test", Containers: []int{c}, Parallelism: 20, Output: strings.Repeat(fmt.Sprint(c), 3*maxSectionOutput)})
+	}
+
+	section := f.Section(outputs)
+	if len(section) > maxCommentLength/2 {
+		t.Errorf("Expected the section to be capped well below a comment, it is %d long", len(section))
+	}
+	if !strings.Contains(section, "* and ") || !strings.Contains(section, "left out, `/circleci logs test` posts all of it") {
+		t.Errorf("Expected the section to say what was left out, got the end %q", section[len(section)-300:])
+	}
+	if !FitsReport([]string{section}) {
+		t.Errorf("Expected a section to fit a report on its own")
+	}
+}
+
+func TestOutputIsCutOnLines(t *testing.T) {
+	// every line is 10 runes of 2 bytes and a line break
+	output := strings.Repeat("ééééééééé!\n", 2*maxSectionOutput/20)
+	f := Failure{Job: circleci.Job{Name: "test"}}
+
+	section := f.Section([]FailedOutput{{Step: "go test", Containers: []int{0}, Parallelism: 1, Output: output}})
+	if !utf8.ValidString(section) {
+		t.Errorf("Expected the section to be valid UTF-8")
+	}
+	if !strings.Contains(section, "```\n...\nééééééééé!\n") {
+		t.Errorf("Expected the output to start on a whole line, got the start %q", section[:100])
+	}
+
+	// without line breaks the output is cut on a rune
+	section = f.Section([]FailedOutput{{Step: "go test", Containers: []int{0}, Parallelism: 1, Output: "x" + strings.Repeat("é", maxSectionOutput)}})
+	if !utf8.ValidString(section) {
+		t.Errorf("Expected the section to be valid UTF-8")
+	}
+}
+
+func TestLogCommentsAreCutOnLines(t *testing.T) {
+	// lines of 3 byte runes that don't line up with the comment length
+	line := strings.Repeat("€", 333) + "\n"
+	output := strings.Repeat(line, 2*maxCommentLength/len(line))
+
+	comments := LogComments("test", output)
+	if len(comments) < 2 {
+		t.Fatalf("Expected the output to be split, got %d comments", len(comments))
+	}
+
+	joined := ""
+	for i, c := range comments {
+		if len(c) > maxCommentLength+100 {
+			t.Errorf("Expected comment %d to be capped, it is %d long", i, len(c))
+		}
+		if !utf8.ValidString(c) {
+			t.Errorf("Expected comment %d to be valid UTF-8", i)
+		}
+		body := strings.TrimSuffix(c[strings.Index(c, "```\n")+4:], "\n```")
+		for _, l := range strings.Split(body, "\n") {
+			if l+"\n" != line {
+				t.Errorf("Expected comment %d to hold whole lines, got a line of %d bytes", i, len(l))
+				break
+			}
+		}
+		joined = joined + body + "\n"
+	}
+	if joined != output {
+		t.Errorf("Expected the comments to hold all the output")
+	}
+
+	// without line breaks the output is cut on a rune
+	for i, c := range LogComments("test", "x"+strings.Repeat("€", maxCommentLength)) {
+		if !utf8.ValidString(c) {
+			t.Errorf("Expected comment %d to be valid UTF-8", i)
+		}
+	}
+}
+
+func TestContainersMatchWholeTestNames(t *testing.T) {
+	outputs := []FailedOutput{
+		{Step: "go test", Containers: []int{0}, Parallelism: 3, Output: "--- FAIL: TestAddMore (0.01s)\n"},
+		{Step: "go test", Containers: []int{1}, Parallelism: 3, Output: "--- FAIL: TestAdd (0.01s)\n"},
+		{Step: "go test", Containers: []int{2}, Parallelism: 3, Output: "--- FAIL: TestAdd/negative (0.00s)\n"},
+	}
+
+	containers := TestContainers(circleci.TestResult{Name: "TestAdd"}, outputs)
+	if fmt.Sprint(containers) != "[1 2]" {
+		t.Errorf("Expected TestAdd on containers 1 and 2, got %v", containers)
+	}
+
+	containers = TestContainers(circleci.TestResult{Name: "TestAddMore"}, outputs)
+	if fmt.Sprint(containers) != "[0]" {
+		t.Errorf("Expected TestAddMore on container 0, got %v", containers)
+	}
+}
+
+func TestFlakyFailuresAreNoted(t *testing.T) {
+	history := flaky.NewMemoryStore()
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 60; i++ {
+		at := start.Add(-time.Duration(i) * time.Hour)
+		sha := fmt.Sprint(i)
+		history.Record([]flaky.Outcome{
+			{Repo: "octo/app", Name: flaky.JobName("build", "test"), Branch: "main", SHA: sha, Failed: i < 4, Time: at},
+			{Repo: "octo/app", Name: flaky.TestName("pkg", "TestAdd"), Branch: "main", SHA: sha, Failed: i < 4, Time: at},
+			{Repo: "octo/app", Name: flaky.TestName("pkg", "TestSub"), Branch: "main", SHA: sha, Failed: true, Time: at},
+		})
+	}
+
+	f := Failure{Workflow: "build", Job: circleci.Job{Name: "test"}, Tests: []FailedTest{
+		{TestResult: circleci.TestResult{Classname: "pkg", Name: "TestAdd"}},
+		{TestResult: circleci.TestResult{Classname: "pkg", Name: "TestSub"}},
+	}}
+	err := MarkFlaky(history, "octo/app", "main", &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	section := f.Section(nil)
+	if !strings.HasPrefix(section, "Build Failed :cry: `test` _likely flaky (failed 4/50 recent runs on main)_\n") {
+		t.Errorf("Expected the job to be noted as flaky, got %s", section)
+	}
+	if !strings.Contains(section, "* `pkg.TestAdd` _likely flaky (failed 4/50 recent runs on main)_\n") {
+		t.Errorf("Expected TestAdd to be noted as flaky, got %s", section)
+	}
+	if !strings.Contains(section, "* `pkg.TestSub`\n") {
+		t.Errorf("Expected TestSub, which always fails, not to be noted, got %s", section)
+	}
+}
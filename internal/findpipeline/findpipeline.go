@@ -0,0 +1,94 @@
+// Package findpipeline is the first task of the step function, it finds the pipeline CircleCI created for the commit
+package findpipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/codingdiaz/circleci-feedback/internal/feedback"
+	"github.com/codingdiaz/circleci-feedback/internal/logging"
+	"github.com/codingdiaz/circleci-feedback/internal/metrics"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/tracing"
+)
+
+const (
+	defaultSearchTimeout  = 15 * time.Minute
+	defaultSearchInterval = 10 * time.Second
+)
+
+// Handler looks for the pipeline, it is kept across warm invocations so the pipelines listed while waiting
+// for a new one are revalidated with their ETag rather than fetched again
+// The main of the function wires in the real services, tests use fakes
+type Handler struct {
+	Config   stepfunc.ConfigSource // read for every invocation
+	CircleCI stepfunc.CircleCIClients
+}
+
+// Handle is the find_pipeline_id task, it is traced as a child of the webhook that started the execution
+func (h *Handler) Handle(ctx context.Context, in stepfunc.Data) (stepfunc.Data, error) {
+	defer metrics.Flush()
+	defer tracing.Flush()
+
+	ctx, span := in.StartSpan(ctx, "find_pipeline_id")
+	out, err := h.findPipeline(ctx, in)
+	span.SetAttribute("pipeline_found", out.PipelineFound)
+	span.End(err)
+
+	return out, err
+}
+
+// findPipeline looks for the pipeline CircleCI created for the commit
+func (h *Handler) findPipeline(ctx context.Context, in stepfunc.Data) (stepfunc.Data, error) {
+	logger := in.Logger()
+
+	// get configuration for lambda function to run
+	c, err := h.Config.Config()
+	if err != nil {
+		logger.Error("Error getting lambda function configuration", "error", err)
+		return in, fmt.Errorf("Error getting lambda function configuration, error: %s", err)
+	}
+
+	// executions following a rerun already know their pipeline
+	if in.PipelineID != "" {
+		in.PipelineFound = true
+		return in, nil
+	}
+
+	timeout, err := stepfunc.DurationFromEnv("FIND_PIPELINE_TIMEOUT", defaultSearchTimeout)
+	if err != nil {
+		return in, err
+	}
+	interval, err := stepfunc.DurationFromEnv("FIND_PIPELINE_INTERVAL", defaultSearchInterval)
+	if err != nil {
+		return in, err
+	}
+
+	if in.PipelineSearchStartedAt.IsZero() {
+		in.PipelineSearchStartedAt = time.Now()
+	}
+
+	metrics.PollIterations.Inc("find_pipeline_id")
+	client := h.CircleCI.Client(ctx, c, logger)
+
+	// look for the pipelineid associated with this commit
+	pipeline, err := feedback.FindPipeline(client, c.CircleVCS, in.Owner, in.RepoName, in.Branch, in.CommitSHA)
+	if err == feedback.ErrPipelineNotFound {
+		// CircleCI takes a moment to create the pipeline, the state machine waits and comes back until the timeout
+		in.FindPipelineWaitTime = int(interval.Seconds())
+		in.PipelineSearchTimedOut = time.Since(in.PipelineSearchStartedAt) >= timeout
+		logger.Info("Didn't find a pipeline for the commit yet", "timed_out", in.PipelineSearchTimedOut)
+		return in, nil
+	}
+	if err != nil {
+		logger.Error("Error finding the pipeline for the commit", "error", err)
+		return in, err
+	}
+
+	logger.Info("Found the pipeline for the commit", logging.PipelineID, pipeline.ID)
+	in.PipelineID = pipeline.ID
+	in.PipelineFound = true
+	return in, nil
+
+}
@@ -0,0 +1,91 @@
+package findpipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakes"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+const sha = "0123456789abcdef0123456789abcdef01234567"
+
+func newHandler(circle *fakes.CircleCI) *Handler {
+	return &Handler{
+		Config:   stepfunc.StaticConfig{CircleVCS: "gh"},
+		CircleCI: circle,
+	}
+}
+
+func input() stepfunc.Data {
+	return stepfunc.Data{Owner: "octo", RepoName: "app", Branch: "feature", CommitSHA: sha}
+}
+
+func TestFindPipeline(t *testing.T) {
+	circle := &fakes.CircleCI{Pipelines: []circleci.Pipeline{
+		fakes.Pipeline("other", "gh/octo/app", "feature", "fedcba9876543210fedcba9876543210fedcba98"),
+		fakes.Pipeline("pipeline-1", "gh/octo/app", "feature", sha),
+	}}
+
+	out, err := newHandler(circle).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.PipelineFound || out.PipelineID != "pipeline-1" {
+		t.Errorf("Expected pipeline-1 to be found, got %q, found %t", out.PipelineID, out.PipelineFound)
+	}
+}
+
+func TestFindPipelineOfFork(t *testing.T) {
+	// pull requests from forks are built on a pull/<number> branch
+	circle := &fakes.CircleCI{Pipelines: []circleci.Pipeline{fakes.Pipeline("pipeline-1", "gh/octo/app", "pull/7", sha)}}
+
+	out, err := newHandler(circle).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.PipelineID != "pipeline-1" {
+		t.Errorf("Expected pipeline-1 to be found, got %q", out.PipelineID)
+	}
+}
+
+func TestPipelineNotCreatedYet(t *testing.T) {
+	t.Setenv("FIND_PIPELINE_INTERVAL", "30s")
+
+	out, err := newHandler(&fakes.CircleCI{}).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.PipelineFound || out.PipelineSearchTimedOut {
+		t.Errorf("Expected the search to go on, found %t, timed out %t", out.PipelineFound, out.PipelineSearchTimedOut)
+	}
+	if out.FindPipelineWaitTime != 30 || out.PipelineSearchStartedAt.IsZero() {
+		t.Errorf("Expected to wait 30 seconds from the start of the search, got %d from %s", out.FindPipelineWaitTime, out.PipelineSearchStartedAt)
+	}
+}
+
+func TestPipelineSearchTimesOut(t *testing.T) {
+	t.Setenv("FIND_PIPELINE_TIMEOUT", "1ns")
+
+	out, err := newHandler(&fakes.CircleCI{}).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.PipelineFound || !out.PipelineSearchTimedOut {
+		t.Errorf("Expected the search to time out, found %t, timed out %t", out.PipelineFound, out.PipelineSearchTimedOut)
+	}
+}
+
+func TestRerunKnowsItsPipeline(t *testing.T) {
+	in := input()
+	in.PipelineID = "pipeline-1"
+
+	out, err := newHandler(&fakes.CircleCI{}).Handle(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.PipelineFound || out.PipelineID != "pipeline-1" {
+		t.Errorf("Expected the pipeline of the rerun to be kept, got %q, found %t", out.PipelineID, out.PipelineFound)
+	}
+}
@@ -0,0 +1,140 @@
+package flaky
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	// runTimeFormat sorts lexically in time order, unlike RFC3339Nano which trims trailing zeros
+	runTimeFormat = "2006-01-02T15:04:05.000000000Z"
+	// historyTTL is how long outcomes are kept, enable DynamoDB TTL on expires_at to have them cleaned up
+	historyTTL = 90 * 24 * time.Hour
+	// batchSize is the most items DynamoDB accepts in one BatchWriteItem call
+	batchSize = 25
+	// maxBatchRetries is how many times items DynamoDB didn't write are sent again, waiting twice as long each time
+	maxBatchRetries = 6
+	batchRetryWait  = 50 * time.Millisecond
+)
+
+// runKey is the range key of an outcome, a run is a job of a commit and every outcome it reports shares it
+func runKey(o Outcome) string {
+	return o.Time.UTC().Format(runTimeFormat) + "|" + o.SHA
+}
+
+// DynamoDBStore keeps the history in a DynamoDB table with a string hash key named "key" and a string range key named "run"
+type DynamoDBStore struct {
+	Table string
+
+	svc *dynamodb.DynamoDB
+}
+
+// NewDynamoDBStore returns a DynamoDBStore for table using the default AWS session
+func NewDynamoDBStore(table string) (*DynamoDBStore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DynamoDBStore{Table: table, svc: dynamodb.New(sess, aws.NewConfig())}, nil
+}
+
+// Record adds outcomes to the history
+// A batch can't have the same key twice, DynamoDB would reject all of it, so outcomes of one run are merged first
+func (s *DynamoDBStore) Record(outcomes []Outcome) error {
+	outcomes = dedupe(outcomes)
+	for start := 0; start < len(outcomes); start += batchSize {
+		end := start + batchSize
+		if end > len(outcomes) {
+			end = len(outcomes)
+		}
+
+		requests := []*dynamodb.WriteRequest{}
+		for _, o := range outcomes[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: map[string]*dynamodb.AttributeValue{
+				"key":        {S: aws.String(historyKey(o.Repo, o.Name))},
+				"run":        {S: aws.String(runKey(o))},
+				"repo":       {S: aws.String(o.Repo)},
+				"name":       {S: aws.String(o.Name)},
+				"branch":     {S: aws.String(o.Branch)},
+				"sha":        {S: aws.String(o.SHA)},
+				"failed":     {BOOL: aws.Bool(o.Failed)},
+				"time":       {N: aws.String(strconv.FormatInt(o.Time.UnixNano(), 10))},
+				"expires_at": {N: aws.String(strconv.FormatInt(o.Time.Add(historyTTL).Unix(), 10))},
+			}}})
+		}
+
+		// DynamoDB hands back what it couldn't write when it is throttling
+		items := map[string][]*dynamodb.WriteRequest{s.Table: requests}
+		for retry := 0; len(items[s.Table]) > 0; retry++ {
+			if retry > maxBatchRetries {
+				return fmt.Errorf("Error recording the history, DynamoDB didn't write %d outcomes after %d retries", len(items[s.Table]), maxBatchRetries)
+			}
+			if retry > 0 {
+				time.Sleep(batchRetryWait << uint(retry-1))
+			}
+
+			resp, err := s.svc.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: items})
+			if err != nil {
+				return err
+			}
+			items = resp.UnprocessedItems
+		}
+	}
+
+	return nil
+}
+
+// Recent returns up to limit of the most recent outcomes of name in repo, newest first
+func (s *DynamoDBStore) Recent(repo, name string, limit int) ([]Outcome, error) {
+	resp, err := s.svc.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("#key = :key"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key": aws.String("key"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": {S: aws.String(historyKey(repo, name))},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := []Outcome{}
+	for _, item := range resp.Items {
+		o := Outcome{
+			Repo:   stringAttribute(item, "repo"),
+			Name:   stringAttribute(item, "name"),
+			Branch: stringAttribute(item, "branch"),
+			SHA:    stringAttribute(item, "sha"),
+		}
+		if item["failed"] != nil {
+			o.Failed = aws.BoolValue(item["failed"].BOOL)
+		}
+		if item["time"] != nil {
+			nanos, err := strconv.ParseInt(aws.StringValue(item["time"].N), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			o.Time = time.Unix(0, nanos)
+		}
+		outcomes = append(outcomes, o)
+	}
+
+	return outcomes, nil
+}
+
+func stringAttribute(item map[string]*dynamodb.AttributeValue, name string) string {
+	if item[name] == nil {
+		return ""
+	}
+	return aws.StringValue(item[name].S)
+}
@@ -0,0 +1,97 @@
+package flaky
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps the history in a JSON file, this is meant for self hosting on a single machine
+// It is safe for concurrent use within one process but not across processes sharing the file
+type FileStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFileStore returns a FileStore backed by the file at path, the file is created on the first record
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+// Record adds outcomes to the history
+func (s *FileStore) Record(outcomes []Outcome) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	add(history, outcomes)
+	return s.save(history)
+}
+
+// Recent returns up to limit of the most recent outcomes of name in repo, newest first
+func (s *FileStore) Recent(repo, name string, limit int) ([]Outcome, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return recent(history, repo, name, limit), nil
+}
+
+func (s *FileStore) load() (map[string][]Outcome, error) {
+	history := map[string][]Outcome{}
+
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading history file %s, error: %s", s.Path, err)
+	}
+
+	if len(b) == 0 {
+		return history, nil
+	}
+
+	err = json.Unmarshal(b, &history)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing history file %s, error: %s", s.Path, err)
+	}
+
+	return history, nil
+}
+
+// save writes to a temporary file first so a crash never leaves a half written file behind
+func (s *FileStore) save(history map[string][]Outcome) error {
+	b, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing history file %s, error: %s", s.Path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing history file %s, error: %s", s.Path, err)
+	}
+
+	return os.Rename(tmp.Name(), s.Path)
+}
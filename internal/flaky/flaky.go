@@ -0,0 +1,153 @@
+// Package flaky keeps a history of job and test outcomes per repo and uses it to spot flaky failures
+package flaky
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// RecentRuns is how many of the most recent runs a flakiness score is computed from
+	RecentRuns = 50
+	// minRuns is the fewest runs a score needs before a failure is called flaky
+	minRuns = 5
+	// maxFailureRate separates flaky from broken, something failing more often than this is just failing
+	maxFailureRate = 0.5
+)
+
+// Outcome is a single run of a job or a test
+type Outcome struct {
+	Repo   string    `json:"repo"`   // owner/repo
+	Name   string    `json:"name"`   // JobName or TestName
+	Branch string    `json:"branch"` // branch the run built
+	SHA    string    `json:"sha"`
+	Failed bool      `json:"failed"`
+	Time   time.Time `json:"time"`
+}
+
+// dedupe merges outcomes of the same run of the same name, a test can be reported more than once by one job,
+// for example by gotestsum --rerun-fails or duplicate JUnit entries, and counts as failed if any report failed
+func dedupe(outcomes []Outcome) []Outcome {
+	deduped := []Outcome{}
+	seen := map[string]int{}
+	for _, o := range outcomes {
+		key := historyKey(o.Repo, o.Name) + "|" + runKey(o)
+		if i, ok := seen[key]; ok {
+			deduped[i].Failed = deduped[i].Failed || o.Failed
+			continue
+		}
+		seen[key] = len(deduped)
+		deduped = append(deduped, o)
+	}
+
+	return deduped
+}
+
+// JobName is the history name of a job, job names are only unique within a workflow
+func JobName(workflow, job string) string {
+	return fmt.Sprintf("job:%s/%s", workflow, job)
+}
+
+// TestName is the history name of a test
+func TestName(classname, name string) string {
+	if classname == "" {
+		return "test:" + name
+	}
+	return fmt.Sprintf("test:%s.%s", classname, name)
+}
+
+// Store keeps the outcome history
+type Store interface {
+	// Record adds outcomes to the history
+	Record(outcomes []Outcome) error
+	// Recent returns up to limit of the most recent outcomes of name in repo, newest first
+	Recent(repo, name string, limit int) ([]Outcome, error)
+}
+
+// NewStore returns the store for kind ("memory", "file" or "dynamodb")
+// location is the file path for the file store and the table name for the dynamodb store
+func NewStore(kind, location string) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		if location == "" {
+			return nil, fmt.Errorf("a file path is required for the file history store")
+		}
+		return NewFileStore(location), nil
+	case "dynamodb":
+		if location == "" {
+			return nil, fmt.Errorf("a table name is required for the dynamodb history store")
+		}
+		return NewDynamoDBStore(location)
+	default:
+		return nil, fmt.Errorf("unknown history store %q, expected one of memory, file or dynamodb", kind)
+	}
+}
+
+// Score is how often something failed in its recent runs
+type Score struct {
+	Failures int
+	Runs     int
+	Branch   string // the branch the runs are from, empty when they are from every branch
+	// FlippedOnSameSHA means it both passed and failed on one commit, a failure that isn't caused by code
+	FlippedOnSameSHA bool
+}
+
+// Assess scores history (newest first), preferring the runs on branch since failures on
+// pull request branches are usually real, it falls back to every run when branch has too few
+func Assess(history []Outcome, branch string) Score {
+	onBranch := []Outcome{}
+	for _, o := range history {
+		if o.Branch == branch {
+			onBranch = append(onBranch, o)
+		}
+	}
+
+	score := Score{}
+	runs := history
+	if branch != "" && len(onBranch) >= minRuns {
+		runs = onBranch
+		score.Branch = branch
+	}
+	if len(runs) > RecentRuns {
+		runs = runs[:RecentRuns]
+	}
+
+	results := map[string]bool{}
+	for _, o := range runs {
+		score.Runs++
+		if o.Failed {
+			score.Failures++
+		}
+
+		if failed, ok := results[o.SHA]; ok && failed != o.Failed && o.SHA != "" {
+			score.FlippedOnSameSHA = true
+		}
+		results[o.SHA] = o.Failed
+	}
+
+	return score
+}
+
+// Flaky reports whether the failures look flaky rather than broken
+func (s Score) Flaky() bool {
+	if s.FlippedOnSameSHA {
+		return true
+	}
+
+	return s.Runs >= minRuns && s.Failures > 0 && float64(s.Failures)/float64(s.Runs) <= maxFailureRate
+}
+
+// String describes the score for a failure report, for example "failed 4/50 recent runs on main"
+func (s Score) String() string {
+	description := fmt.Sprintf("failed %d/%d recent runs", s.Failures, s.Runs)
+	if s.Branch != "" {
+		description = description + " on " + s.Branch
+	}
+	if s.FlippedOnSameSHA {
+		description = description + ", passed and failed on the same commit"
+	}
+
+	return description
+}
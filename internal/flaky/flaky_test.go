@@ -0,0 +1,70 @@
+package flaky
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDuplicateOutcomesOfARun(t *testing.T) {
+	run := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	outcomes := dedupe([]Outcome{
+		{Repo: "octo/app", Name: TestName("pkg", "TestAdd"), SHA: "abc", Failed: true, Time: run},
+		{Repo: "octo/app", Name: TestName("pkg", "TestAdd"), SHA: "abc", Failed: false, Time: run},
+		{Repo: "octo/app", Name: TestName("pkg", "TestSub"), SHA: "abc", Failed: false, Time: run},
+	})
+
+	if len(outcomes) != 2 || !outcomes[0].Failed || outcomes[1].Failed {
+		t.Errorf("Expected TestAdd once as failed and TestSub as passed, got %+v", outcomes)
+	}
+}
+
+// runs returns total runs of a test on branch, newest first, the newest failures of them failed
+func runs(branch string, failures, total int) []Outcome {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	outcomes := []Outcome{}
+	for i := 0; i < total; i++ {
+		outcomes = append(outcomes, Outcome{
+			Repo:   "octo/app",
+			Name:   TestName("pkg", "TestAdd"),
+			Branch: branch,
+			SHA:    fmt.Sprintf("%s-%d", branch, i),
+			Failed: i < failures,
+			Time:   start.Add(-time.Duration(i) * time.Hour),
+		})
+	}
+
+	return outcomes
+}
+
+func TestAssess(t *testing.T) {
+	flipped := []Outcome{
+		{SHA: "abc", Branch: "feature", Failed: true},
+		{SHA: "abc", Branch: "feature", Failed: false},
+	}
+
+	tests := []struct {
+		name    string
+		history []Outcome
+		branch  string
+		score   string
+		flaky   bool
+	}{
+		{"too few runs", runs("main", 1, 4), "", "failed 1/4 recent runs", false},
+		{"enough runs", runs("main", 1, 5), "", "failed 1/5 recent runs", true},
+		{"never failed", runs("main", 0, 50), "", "failed 0/50 recent runs", false},
+		{"fails half the time", runs("main", 5, 10), "", "failed 5/10 recent runs", true},
+		{"fails more than half the time", runs("main", 6, 10), "", "failed 6/10 recent runs", false},
+		{"only the recent runs count", runs("main", 4, 80), "", "failed 4/50 recent runs", true},
+		{"runs on the branch", append(runs("main", 4, 50), runs("feature", 9, 10)...), "main", "failed 4/50 recent runs on main", true},
+		{"too few runs on the branch", append(runs("main", 1, 4), runs("feature", 2, 6)...), "main", "failed 3/10 recent runs", true},
+		{"flipped on one commit", flipped, "", "failed 1/2 recent runs, passed and failed on the same commit", true},
+	}
+
+	for _, tt := range tests {
+		score := Assess(tt.history, tt.branch)
+		if score.String() != tt.score || score.Flaky() != tt.flaky {
+			t.Errorf("%s: expected %q and flaky %v, got %q and flaky %v", tt.name, tt.score, tt.flaky, score, score.Flaky())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package flaky
+
+import (
+	"sort"
+	"sync"
+)
+
+// maxHistory is how many outcomes the memory and file stores keep per name
+const maxHistory = 4 * RecentRuns
+
+// MemoryStore keeps the history in memory
+// In lambda the history only lives as long as the warm container
+type MemoryStore struct {
+	mu      sync.Mutex
+	history map[string][]Outcome
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{history: map[string][]Outcome{}}
+}
+
+// Record adds outcomes to the history
+func (s *MemoryStore) Record(outcomes []Outcome) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	add(s.history, outcomes)
+	return nil
+}
+
+// Recent returns up to limit of the most recent outcomes of name in repo, newest first
+func (s *MemoryStore) Recent(repo, name string, limit int) ([]Outcome, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return recent(s.history, repo, name, limit), nil
+}
+
+func historyKey(repo, name string) string {
+	return repo + "|" + name
+}
+
+// add appends outcomes to history keeping every name sorted newest first and capped at maxHistory
+func add(history map[string][]Outcome, outcomes []Outcome) {
+	// the same run can be recorded more than once, for example a base branch pipeline checked for many pull requests
+	for _, o := range dedupe(outcomes) {
+		key := historyKey(o.Repo, o.Name)
+		if !recorded(history[key], o) {
+			history[key] = append(history[key], o)
+		}
+	}
+
+	for key, h := range history {
+		sort.SliceStable(h, func(i, j int) bool { return h[i].Time.After(h[j].Time) })
+		if len(h) > maxHistory {
+			h = h[:maxHistory]
+		}
+		history[key] = h
+	}
+}
+
+func recorded(history []Outcome, o Outcome) bool {
+	for _, h := range history {
+		if h.SHA == o.SHA && h.Time.Equal(o.Time) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func recent(history map[string][]Outcome, repo, name string, limit int) []Outcome {
+	h := history[historyKey(repo, name)]
+	if len(h) > limit {
+		h = h[:limit]
+	}
+
+	return append([]Outcome{}, h...)
+}
@@ -0,0 +1,65 @@
+// Package logging sets up the structured logs of the functions
+// Every log line is a JSON object, the fields below identify what it is about so the logs of one
+// pull request can be followed from the webhook through every step of its execution
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Field names shared by every function
+const (
+	// CorrelationID is the X-GitHub-Delivery ID of the webhook that started the work
+	CorrelationID = "correlation_id"
+	Owner         = "owner"
+	Repo          = "repo"
+	PullRequest   = "pr"
+	SHA           = "sha"
+	PipelineID    = "pipeline_id"
+	WorkflowID    = "workflow_id"
+	JobNumber     = "job_number"
+)
+
+// Setup makes JSON on stdout, at the level named by LOG_LEVEL (debug, info, warn or error, info by default),
+// the default logger, lines logged with the log package end up there as info
+func Setup() error {
+	level, err := level()
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+	return nil
+}
+
+// SetupCLI is Setup for commands run in a terminal, it logs text on stderr so stdout only has their output
+func SetupCLI() error {
+	level, err := level()
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	return nil
+}
+
+func level() (slog.Level, error) {
+	level := slog.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		err := level.UnmarshalText([]byte(strings.ToUpper(v)))
+		if err != nil {
+			return level, fmt.Errorf("Error parsing LOG_LEVEL, error: %s", err)
+		}
+	}
+
+	return level, nil
+}
+
+// Fatal logs msg as an error and exits, for errors setting up a function before it handles anything
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// maxEMFValues is the most values CloudWatch takes for one metric in one EMF line
+const maxEMFValues = 100
+
+// Flush writes what changed in Default since the last flush to stdout as EMF, when running in Lambda
+// Functions flush at the end of every invocation, lambda can freeze the process as soon as the handler returns
+func Flush() {
+	if !Default.EMF {
+		return
+	}
+
+	err := Default.WriteEMF(os.Stdout, time.Now())
+	if err != nil {
+		slog.Error("Error writing metrics", "error", err)
+	}
+}
+
+// WriteEMF writes one CloudWatch Embedded Metric Format line per series of r that changed since the last call,
+// counters are written as the increase since then and histograms as the observations since then
+func (r *Registry) WriteEMF(w io.Writer, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	for _, m := range r.metrics {
+		for _, s := range m.sorted() {
+			lines := []interface{}{}
+			unit := "Count"
+			if m.kind == "counter" {
+				if s.value == s.flushed {
+					continue
+				}
+				lines = append(lines, s.value-s.flushed)
+				s.flushed = s.value
+			} else {
+				unit = "Seconds"
+				for start := 0; start < len(s.samples); start += maxEMFValues {
+					end := start + maxEMFValues
+					if end > len(s.samples) {
+						end = len(s.samples)
+					}
+					lines = append(lines, s.samples[start:end])
+				}
+				s.samples = nil
+			}
+
+			for _, value := range lines {
+				err := enc.Encode(emfLine(r.Namespace, m, s, unit, value, now))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// emfLine is the EMF object for value of the series s, the labels of the metric are its dimensions
+func emfLine(namespace string, m *metric, s *series, unit string, value interface{}, now time.Time) map[string]interface{} {
+	line := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": now.UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []interface{}{
+				map[string]interface{}{
+					"Namespace":  namespace,
+					"Dimensions": [][]string{append([]string{}, m.labels...)},
+					"Metrics": []interface{}{
+						map[string]string{"Name": m.name, "Unit": unit},
+					},
+				},
+			},
+		},
+		m.name: value,
+	}
+	for i, name := range m.labels {
+		line[name] = s.values[i]
+	}
+
+	return line
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteEMF(t *testing.T) {
+	r := &Registry{EMF: true, Namespace: "Test"}
+	calls := r.NewCounter("calls_total", "Calls by endpoint", "endpoint")
+	calls.Inc("/repos/:owner/:repo")
+	calls.Inc("/repos/:owner/:repo")
+	calls.Inc("a \"quoted\" \\path")
+	duration := r.NewHistogram("duration_seconds", "Durations", []float64{1, 5}, "status")
+	duration.Observe(0.5, "success")
+	duration.Observe(3, "success")
+	now := time.Unix(1600000000, 0)
+
+	b := &bytes.Buffer{}
+	err := r.WriteEMF(b, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"_aws":{"CloudWatchMetrics":[{"Dimensions":[["endpoint"]],"Metrics":[{"Name":"calls_total","Unit":"Count"}],"Namespace":"Test"}],"Timestamp":1600000000000},"calls_total":2,"endpoint":"/repos/:owner/:repo"}
+{"_aws":{"CloudWatchMetrics":[{"Dimensions":[["endpoint"]],"Metrics":[{"Name":"calls_total","Unit":"Count"}],"Namespace":"Test"}],"Timestamp":1600000000000},"calls_total":1,"endpoint":"a \"quoted\" \\path"}
+{"_aws":{"CloudWatchMetrics":[{"Dimensions":[["status"]],"Metrics":[{"Name":"duration_seconds","Unit":"Seconds"}],"Namespace":"Test"}],"Timestamp":1600000000000},"duration_seconds":[0.5,3],"status":"success"}
+`
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+
+	// only what changed since is written next time, counters as their increase
+	calls.Inc("/repos/:owner/:repo")
+	b.Reset()
+	err = r.WriteEMF(b, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `{"_aws":{"CloudWatchMetrics":[{"Dimensions":[["endpoint"]],"Metrics":[{"Name":"calls_total","Unit":"Count"}],"Namespace":"Test"}],"Timestamp":1600000000000},"calls_total":1,"endpoint":"/repos/:owner/:repo"}
+`
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestEMFSplitsLongHistograms(t *testing.T) {
+	r := &Registry{EMF: true, Namespace: "Test"}
+	duration := r.NewHistogram("duration_seconds", "Durations", []float64{1})
+	for i := 0; i < 2*maxEMFValues+1; i++ {
+		duration.Observe(1)
+	}
+
+	b := &bytes.Buffer{}
+	err := r.WriteEMF(b, time.Unix(1600000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(b.Bytes(), []byte("\n")); lines != 3 {
+		t.Errorf("Expected the observations in 3 lines of at most %d values, got %d lines", maxEMFValues, lines)
+	}
+}
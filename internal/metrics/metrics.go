@@ -0,0 +1,181 @@
+// Package metrics counts what the functions do, how often webhooks come in, how long pipelines take
+// and how many API calls are made
+// The entry server serves them for Prometheus at /metrics, in Lambda they are written to stdout as
+// CloudWatch Embedded Metric Format lines that CloudWatch turns into metrics
+package metrics
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DurationBuckets are the histogram buckets in seconds for the durations of pipelines, from seconds to hours
+var DurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 10800}
+
+// The metrics of the functions, all registered on Default
+var (
+	WebhooksReceived = Default.NewCounter("circleci_feedback_webhooks_received_total",
+		"Webhooks received by GitHub event and response status", "event", "status")
+	ExecutionsStarted = Default.NewCounter("circleci_feedback_executions_started_total",
+		"Step function executions started, by what started them", "trigger")
+	PollIterations = Default.NewCounter("circleci_feedback_poll_iterations_total",
+		"Times a function polled CircleCI", "function")
+	APICalls = Default.NewCounter("circleci_feedback_api_calls_total",
+		"Requests sent to CircleCI and GitHub by endpoint and response status", "service", "method", "endpoint", "status")
+	CommentsPosted = Default.NewCounter("circleci_feedback_comments_posted_total",
+		"Comments created or edited on pull requests", "kind", "action")
+	FailureToReport = Default.NewHistogram("circleci_feedback_failure_to_report_seconds",
+		"Seconds from the first job of a report failing to the report being posted", DurationBuckets)
+	PipelineDuration = Default.NewHistogram("circleci_feedback_pipeline_duration_seconds",
+		"Seconds from the first job of a pipeline starting to the last one stopping, by outcome", DurationBuckets, "status")
+)
+
+// Default is the registry of the metrics above, it emits EMF when running in Lambda
+var Default = &Registry{
+	EMF:       os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "",
+	Namespace: namespace(),
+}
+
+// maxSamples caps the histogram observations kept between two EMF flushes
+const maxSamples = 1000
+
+func namespace() string {
+	if ns := os.Getenv("METRICS_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return "CircleCIFeedback"
+}
+
+// Registry holds counters and histograms, every combination of label values is its own series
+type Registry struct {
+	EMF       bool   // keep what changed between flushes so Flush can write it as EMF
+	Namespace string // CloudWatch namespace of the EMF metrics
+
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+type metric struct {
+	name    string
+	help    string
+	kind    string // counter or histogram
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values  []string
+	value   float64  // counter value, or sum of the histogram observations
+	count   uint64   // histogram observations
+	buckets []uint64 // histogram observations per bucket, not cumulative
+
+	flushed float64   // counter value at the last EMF flush
+	samples []float64 // histogram observations since the last EMF flush
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	r *Registry
+	m *metric
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	r *Registry
+	m *metric
+}
+
+// NewCounter registers a counter with the label names labels
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r: r, m: r.register(name, help, "counter", nil, labels)}
+}
+
+// NewHistogram registers a histogram with upper bounds buckets and the label names labels
+// Observations are durations in seconds
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r: r, m: r.register(name, help, "histogram", buckets, labels)}
+}
+
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// Inc adds one to the series of values, there has to be one value for every label of the counter
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the series of values
+func (c *Counter) Add(v float64, values ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+
+	c.m.get(values).value += v
+}
+
+// Observe adds v to the series of values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+
+	s := h.m.get(values)
+	s.value += v
+	s.count++
+	for i, upper := range h.m.buckets {
+		if v <= upper {
+			s.buckets[i]++
+			break
+		}
+	}
+	if h.r.EMF && len(s.samples) < maxSamples {
+		s.samples = append(s.samples, v)
+	}
+}
+
+// Since observes the seconds since t
+func (h *Histogram) Since(t time.Time, values ...string) {
+	h.Observe(time.Since(t).Seconds(), values...)
+}
+
+// get returns the series of values, the caller holds the lock of the registry
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic("metrics: " + m.name + " takes the labels " + strings.Join(m.labels, ", "))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		if m.kind == "histogram" {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+// sorted returns the series of m ordered by their label values, the caller holds the lock of the registry
+func (m *metric) sorted() []*series {
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, m.series[key])
+	}
+	return out
+}
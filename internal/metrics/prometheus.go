@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handler serves the metrics of Default in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err := Default.WritePrometheus(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// WritePrometheus writes every series of r to w in the Prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b := bufio.NewWriter(w)
+	for _, m := range r.metrics {
+		fmt.Fprintf(b, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(b, "# TYPE %s %s\n", m.name, m.kind)
+
+		for _, s := range m.sorted() {
+			if m.kind == "counter" {
+				fmt.Fprintf(b, "%s%s %s\n", m.name, labels(m.labels, s.values, ""), number(s.value))
+				continue
+			}
+
+			// prometheus buckets count every observation up to their bound
+			var cumulative uint64
+			for i, upper := range m.buckets {
+				cumulative += s.buckets[i]
+				fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, labels(m.labels, s.values, number(upper)), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, labels(m.labels, s.values, "+Inf"), s.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", m.name, labels(m.labels, s.values, ""), number(s.value))
+			fmt.Fprintf(b, "%s_count%s %d\n", m.name, labels(m.labels, s.values, ""), s.count)
+		}
+	}
+
+	return b.Flush()
+}
+
+// labels formats names and values as {name="value",...}, le is added for histogram buckets when it isn't empty
+func labels(names, values []string, le string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+"="+quote(values[i]))
+	}
+	if le != "" {
+		pairs = append(pairs, "le="+quote(le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func number(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := &Registry{}
+	calls := r.NewCounter("calls_total", "Calls by endpoint", "service", "endpoint")
+	calls.Inc("github", "/repos/:owner/:repo")
+	calls.Add(2, "circleci", "/api/v2/pipeline/:id")
+	// label values are escaped
+	calls.Inc("other", "a \"quoted\" \\path\nwith a line break")
+	duration := r.NewHistogram("duration_seconds", "Durations", []float64{1, 5.5}, "status")
+	duration.Observe(0.5, "success")
+	duration.Observe(3, "success")
+	duration.Observe(10, "success")
+
+	b := &bytes.Buffer{}
+	err := r.WritePrometheus(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP calls_total Calls by endpoint
+# TYPE calls_total counter
+calls_total{service="circleci",endpoint="/api/v2/pipeline/:id"} 2
+calls_total{service="github",endpoint="/repos/:owner/:repo"} 1
+calls_total{service="other",endpoint="a \"quoted\" \\path\nwith a line break"} 1
+# HELP duration_seconds Durations
+# TYPE duration_seconds histogram
+duration_seconds_bucket{status="success",le="1"} 1
+duration_seconds_bucket{status="success",le="5.5"} 2
+duration_seconds_bucket{status="success",le="+Inf"} 3
+duration_seconds_sum{status="success"} 13.5
+duration_seconds_count{status="success"} 3
+`
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Transport counts the requests sent through Base in APICalls
+type Transport struct {
+	Service string            // service label of the requests, like circleci or github
+	Base    http.RoundTripper // defaults to http.DefaultTransport
+}
+
+// HTTPClient returns a client that counts its requests as calls to service
+func HTTPClient(service string) *http.Client {
+	return &http.Client{Transport: &Transport{Service: service}}
+}
+
+// RoundTrip sends req with Base and counts it by endpoint and status, requests that get no response have the status error
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	APICalls.Inc(t.Service, req.Method, Endpoint(req.URL), status)
+
+	return resp, err
+}
+
+// Endpoint replaces the parts of the path of u that name a repo or an object with placeholders so that every
+// repo and pipeline adds to the same series, /repos/octo/app/issues/comments/12 is /repos/:owner/:repo/issues/comments/:id
+// Only CircleCI and GitHub API paths are known, requests to other hosts, like the step output CircleCI links to,
+// are labelled by their host, their paths are often signed and would make a series per request
+func Endpoint(u *url.URL) string {
+	if !isAPI(u) {
+		return u.Host
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i < len(parts); i++ {
+		switch {
+		case parts[i] == "repos" && i+2 < len(parts):
+			parts[i+1], parts[i+2] = ":owner", ":repo"
+			i += 2
+		case parts[i] == "project" && i+3 < len(parts):
+			parts[i+1], parts[i+2], parts[i+3] = ":vcs", ":owner", ":repo"
+			i += 3
+		case isID(parts[i]):
+			parts[i] = ":id"
+		}
+	}
+
+	return "/" + strings.Join(parts, "/")
+}
+
+// isAPI says whether u is on the CircleCI or GitHub API, CircleCI has it under /api/v2 and /api/v1.1 on every host,
+// GitHub on api.github.com and under /api/v3 on GitHub Enterprise Server
+func isAPI(u *url.URL) bool {
+	return u.Host == "api.github.com" || strings.HasPrefix(u.Path, "/api/")
+}
+
+// isID says whether s is a number, a UUID or a commit sha
+func isID(s string) bool {
+	if s == "" {
+		return false
+	}
+	if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return true
+	}
+	if len(s) < 16 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF-", c) {
+			return false
+		}
+	}
+
+	return true
+}
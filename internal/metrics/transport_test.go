@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		url      string
+		endpoint string
+	}{
+		{"https://api.github.com/repos/octo/app/issues/comments/12", "/repos/:owner/:repo/issues/comments/:id"},
+		{"https://github.example.com/api/v3/repos/octo/app/pulls/7", "/api/v3/repos/:owner/:repo/pulls/:id"},
+		{"https://circleci.com/api/v2/project/gh/octo/app/pipeline", "/api/v2/project/:vcs/:owner/:repo/pipeline"},
+		{"https://circleci.com/api/v2/pipeline/5034460f-c7c4-4c43-9457-de07e2029e7b/workflow", "/api/v2/pipeline/:id/workflow"},
+		{"https://circleci.com/api/v1.1/project/gh/octo/app/42", "/api/v1.1/project/:vcs/:owner/:repo/:id"},
+		// step output is on another host, with a path signed for each request
+		{"https://circle-production-action-output.s3.amazonaws.com/a1b2c3/output?X-Amz-Signature=abc", "circle-production-action-output.s3.amazonaws.com"},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := Endpoint(u); got != tt.endpoint {
+			t.Errorf("Expected %s to be the endpoint %s, got %s", tt.url, tt.endpoint, got)
+		}
+	}
+}
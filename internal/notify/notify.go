@@ -0,0 +1,172 @@
+// Package notify sends failures to places other than the pull request, like Slack or a webhook
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/codingdiaz/circleci-feedback/internal/feedback"
+	"github.com/codingdiaz/circleci-feedback/internal/flaky"
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+)
+
+// maxOutput is how much of the end of each failed step is sent, like in the pull request report
+const maxOutput = 10000
+
+// httpClient gives up on slow endpoints, a notification is never worth holding up the report
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notification is the failures newly found in a pipeline of a pull request
+type Notification struct {
+	Repo        string    `json:"repo"` // owner/repo
+	PullRequest int       `json:"pull_request"`
+	URL         string    `json:"url"`    // of the pull request
+	Author      string    `json:"author"` // login of who opened the pull request
+	Branch      string    `json:"branch"`
+	SHA         string    `json:"sha"`
+	PipelineID  string    `json:"pipeline_id"`
+	Failures    []Failure `json:"failures"`
+}
+
+// Failure is a failed job
+type Failure struct {
+	Workflow  string   `json:"workflow"`
+	Job       string   `json:"job"`
+	JobNumber int      `json:"job_number"`
+	Notes     []string `json:"notes,omitempty"`
+	Tests     []Test   `json:"tests,omitempty"`
+	Steps     []Step   `json:"steps"`
+}
+
+// Test is a failed test of a failed job
+type Test struct {
+	Name       string   `json:"name"`
+	File       string   `json:"file,omitempty"`
+	Message    string   `json:"message,omitempty"`
+	Containers []int    `json:"containers,omitempty"`
+	Notes      []string `json:"notes,omitempty"`
+	Owners     []string `json:"owners,omitempty"` // code owners of File, @login, @org/team or emails
+}
+
+// Step is a failed step of a failed job, Output is the end of its output
+type Step struct {
+	Name       string `json:"name"`
+	Containers []int  `json:"containers,omitempty"` // only set for jobs with parallelism
+	Output     string `json:"output"`
+}
+
+// NewFailure converts a failure of the report and the output of its failed steps for notifications
+func NewFailure(f feedback.Failure, outputs []feedback.FailedOutput) Failure {
+	n := Failure{
+		Workflow:  f.Workflow,
+		Job:       f.Job.Name,
+		JobNumber: f.Job.JobNumber,
+		Notes:     f.Notes,
+		Steps:     []Step{},
+	}
+
+	for _, t := range f.Tests {
+		n.Tests = append(n.Tests, Test{
+			Name:       strings.TrimPrefix(flaky.TestName(t.Classname, t.Name), "test:"),
+			File:       t.File,
+			Message:    t.Message,
+			Containers: feedback.TestContainers(t.TestResult, outputs),
+			Notes:      t.Notes,
+			Owners:     t.Owners,
+		})
+	}
+
+	for _, o := range outputs {
+		step := Step{Name: o.Step, Output: tail(o.Output, maxOutput)}
+		if o.Parallelism > 1 {
+			step.Containers = o.Containers
+		}
+		n.Steps = append(n.Steps, step)
+	}
+
+	return n
+}
+
+// Notifier sends notifications somewhere
+type Notifier interface {
+	Notify(n Notification) error
+	// String names where notifications go for logs, without secrets
+	String() string
+}
+
+// FromSettings returns a notifier for every destination in the settings of a repo
+func FromSettings(s repoconfig.Settings) []Notifier {
+	notifiers := []Notifier{}
+	if s.Slack != nil && s.Slack.WebhookURL != "" {
+		notifiers = append(notifiers, &Slack{WebhookURL: s.Slack.WebhookURL, Users: s.SlackUsers})
+	}
+	for _, w := range s.Webhooks {
+		notifiers = append(notifiers, &Webhook{URL: w.URL, Secret: w.Secret})
+	}
+
+	return notifiers
+}
+
+// tail cuts s down to its last n bytes, that is where a failure usually is, without splitting a rune
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	start := len(s) - n
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return "...\n" + s[start:]
+}
+
+// head cuts s down to its first n bytes without splitting a rune
+func head(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	end := n
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "..."
+}
+
+// post sends body to url and fails on any status other than 2xx
+func post(url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("got status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/codingdiaz/circleci-feedback/internal/feedback"
+)
+
+const (
+	// Slack rejects section text over 3000 characters and messages over 50 blocks
+	maxSlackText   = 2900
+	maxSlackBlocks = 50
+)
+
+// Slack posts notifications as Block Kit messages through an incoming webhook
+type Slack struct {
+	WebhookURL string
+	// Users maps GitHub logins to Slack user IDs, people without one are named by their GitHub login
+	Users map[string]string
+}
+
+// slackBlock is a Block Kit section or context block
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackMessage struct {
+	// Text is shown in notifications and by clients that can't show blocks
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// Notify posts the notification
+func (s *Slack) Notify(n Notification) error {
+	body, err := json.Marshal(s.message(n))
+	if err != nil {
+		return err
+	}
+
+	err = post(s.WebhookURL, body, nil)
+	if err != nil {
+		return fmt.Errorf("Error posting to slack, error: %s", err)
+	}
+
+	return nil
+}
+
+func (s *Slack) String() string {
+	return "slack"
+}
+
+// message formats a notification as a Block Kit message
+func (s *Slack) message(n Notification) slackMessage {
+	title := fmt.Sprintf("%d failed %s on %s#%d", len(n.Failures), plural(len(n.Failures), "job", "jobs"), n.Repo, n.PullRequest)
+	msg := slackMessage{
+		Text:   title,
+		Blocks: []slackBlock{section(fmt.Sprintf("*CircleCI:* %d failed %s on <%s|%s#%d> (`%s`)", len(n.Failures), plural(len(n.Failures), "job", "jobs"), n.URL, n.Repo, n.PullRequest, n.Branch))},
+	}
+	if n.Author != "" {
+		msg.Blocks[0].Text.Text = s.mention(n.Author) + " " + msg.Blocks[0].Text.Text
+	}
+
+	for i, f := range n.Failures {
+		blocks := s.failureBlocks(f)
+		// one block is kept for saying the rest is on the pull request
+		if len(msg.Blocks)+len(blocks) > maxSlackBlocks-1 {
+			msg.Blocks = append(msg.Blocks, slackBlock{
+				Type:     "context",
+				Elements: []slackText{{Type: "mrkdwn", Text: fmt.Sprintf("%d more on <%s|the pull request>", len(n.Failures)-i, n.URL)}},
+			})
+			break
+		}
+		msg.Blocks = append(msg.Blocks, blocks...)
+	}
+
+	return msg
+}
+
+func (s *Slack) failureBlocks(f Failure) []slackBlock {
+	text := fmt.Sprintf(":x: *`%s`* in `%s`", f.Job, f.Workflow)
+	if len(f.Notes) > 0 {
+		text = text + " _" + strings.Join(f.Notes, ", ") + "_"
+	}
+	for _, t := range f.Tests {
+		notes := t.Notes
+		if len(t.Containers) > 0 {
+			notes = append(notes[:len(notes):len(notes)], "ran on "+feedback.ContainerList(t.Containers))
+		}
+
+		text = text + fmt.Sprintf("\n• `%s`", t.Name)
+		if len(notes) > 0 {
+			text = text + " _" + strings.Join(notes, ", ") + "_"
+		}
+		if len(t.Owners) > 0 {
+			owners := []string{}
+			for _, o := range t.Owners {
+				owners = append(owners, s.mention(strings.TrimPrefix(o, "@")))
+			}
+			text = text + " cc " + strings.Join(owners, " ")
+		}
+	}
+
+	blocks := []slackBlock{section(text)}
+	for _, step := range f.Steps {
+		label := fmt.Sprintf("`%s`", step.Name)
+		if len(step.Containers) > 0 {
+			label = label + " on " + feedback.ContainerList(step.Containers)
+		}
+		label = head(label, maxSlackText/4)
+
+		// the end of the output is kept, that is where the failure usually is,
+		// it is cut to fit the section with the label and the fence so section doesn't cut the fence off
+		output := tail(step.Output, maxSlackText-len(label)-20)
+		blocks = append(blocks, section(label+"\n```"+output+"```"))
+	}
+
+	return blocks
+}
+
+// mention mentions a GitHub user in Slack when their Slack user ID is known, teams and emails are named as they are
+func (s *Slack) mention(login string) string {
+	if id, ok := s.Users[login]; ok {
+		return "<@" + id + ">"
+	}
+	if strings.Contains(login, "@") {
+		return login
+	}
+	return "@" + login
+}
+
+func section(text string) slackBlock {
+	text = head(text, maxSlackText)
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}}
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSlackMessageIsCapped(t *testing.T) {
+	n := Notification{Repo: "octo/app", PullRequest: 7, URL: "https://github.com/octo/app/pull/7", Branch: "feature"}
+	for i := 0; i < 60; i++ {
+		n.Failures = append(n.Failures, Failure{
+			Workflow: "build",
+			Job:      fmt.Sprintf("test-%d", i),
+			Steps: []Step{
+				// 3 byte runes that don't line up with the cap
+				{Name: "go test", Output: "x" + strings.Repeat("€", 2*maxSlackText)},
+				{Name: "go vet", Output: "ok"},
+			},
+		})
+	}
+
+	msg := (&Slack{}).message(n)
+	if len(msg.Blocks) > maxSlackBlocks {
+		t.Errorf("Expected at most %d blocks, got %d", maxSlackBlocks, len(msg.Blocks))
+	}
+	last := msg.Blocks[len(msg.Blocks)-1]
+	if last.Type != "context" || !strings.Contains(last.Elements[0].Text, "more on <https://github.com/octo/app/pull/7|the pull request>") {
+		t.Errorf("Expected the last block to say the rest is on the pull request, got %+v", last)
+	}
+
+	for i, b := range msg.Blocks {
+		if b.Text == nil {
+			continue
+		}
+		if utf8.RuneCountInString(b.Text.Text) > 3000 || !utf8.ValidString(b.Text.Text) {
+			t.Errorf("Expected block %d to be valid text of at most 3000 characters, it is %d bytes", i, len(b.Text.Text))
+		}
+		if strings.Contains(b.Text.Text, "```") && (strings.Count(b.Text.Text, "```") != 2 || !strings.HasSuffix(b.Text.Text, "```")) {
+			t.Errorf("Expected the output of block %d to be fenced, got the end %q", i, b.Text.Text[len(b.Text.Text)-20:])
+		}
+	}
+}
+
+func TestSlackLongStepName(t *testing.T) {
+	n := Notification{Repo: "octo/app", PullRequest: 7, Failures: []Failure{{
+		Workflow: "build",
+		Job:      "test",
+		Steps:    []Step{{Name: strings.Repeat("é", maxSlackText), Output: "FAIL"}},
+	}}}
+
+	msg := (&Slack{}).message(n)
+	text := msg.Blocks[len(msg.Blocks)-1].Text.Text
+	if len(text) > maxSlackText+3 || !utf8.ValidString(text) || !strings.HasSuffix(text, "```FAIL```") {
+		t.Errorf("Expected the step name to be cut and the output kept, got %d bytes ending in %q", len(text), text[len(text)-20:])
+	}
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		tail string
+	}{
+		{"short", 10, "short"},
+		{"abcdef", 3, "...\ndef"},
+		{"aéb", 2, "...\nb"}, // é is 2 bytes, the cut doesn't split it
+		{"aéb", 3, "...\néb"},
+		{"abc", -5, "...\n"},
+	}
+
+	for _, tt := range tests {
+		if got := tail(tt.s, tt.n); got != tt.tail {
+			t.Errorf("Expected tail(%q, %d) to be %q, got %q", tt.s, tt.n, tt.tail, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the payload, hex encoded and prefixed with "sha256=" like GitHub webhooks
+const SignatureHeader = "X-Feedback-Signature-256"
+
+// Webhook posts notifications as JSON to URL
+// Receivers check the payload came from the app by computing the HMAC-SHA256 of the body with Secret
+type Webhook struct {
+	URL    string
+	Secret string
+}
+
+// Notify posts the notification
+func (w *Webhook) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	err = post(w.URL, body, map[string]string{SignatureHeader: Sign(w.Secret, body)})
+	if err != nil {
+		return fmt.Errorf("Error posting to webhook %s, error: %s", w, err)
+	}
+
+	return nil
+}
+
+func (w *Webhook) String() string {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return "webhook"
+	}
+	return "webhook " + u.Host
+}
+
+// Sign returns the value of SignatureHeader for body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookIsSigned(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer server.Close()
+
+	n := Notification{Repo: "octo/app", PullRequest: 7, Failures: []Failure{{Workflow: "build", Job: "test", Steps: []Step{}}}}
+	err := (&Webhook{URL: server.URL, Secret: "shh"}).Notify(n)
+	if err != nil {
+		t.Fatalf("Expected the notification to be posted, got %s", err)
+	}
+
+	// what a receiver does to check the payload
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if header.Get("X-Feedback-Signature-256") != expected {
+		t.Errorf("Expected the signature %s, got %q", expected, header.Get("X-Feedback-Signature-256"))
+	}
+	if header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a JSON payload, got %s", header.Get("Content-Type"))
+	}
+
+	received := Notification{}
+	if err := json.Unmarshal(body, &received); err != nil || received.Repo != "octo/app" || received.PullRequest != 7 {
+		t.Errorf("Expected the notification as the payload, got %s", body)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 of "The quick brown fox jumps over the lazy dog" keyed with "key"
+	got := Sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+	if got != "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8" {
+		t.Errorf("Expected the HMAC-SHA256 of the body, got %s", got)
+	}
+}
+
+func TestWebhookFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := (&Webhook{URL: server.URL, Secret: "shh"}).Notify(Notification{})
+	if err == nil {
+		t.Errorf("Expected a 500 to fail the notification")
+	}
+}
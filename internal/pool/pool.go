@@ -0,0 +1,66 @@
+// Package pool runs independent pieces of work, like CircleCI requests, a bounded number at a time
+package pool
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultSize is how many pieces of work run at a time when no size is configured
+const DefaultSize = 8
+
+// Run calls fn for every i from 0 to n-1, at most size at a time, and waits for them to finish
+// fn should write its result to index i of a slice made beforehand so results keep the order of the input
+// The first error cancels ctx for the calls that are running, stops the calls that haven't started and is returned
+func Run(ctx context.Context, size, n int, fn func(ctx context.Context, i int) error) error {
+	if size < 1 {
+		size = DefaultSize
+	}
+	if size > n {
+		size = n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	work := make(chan int)
+
+	for w := 0; w < size; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				err := fn(ctx, i)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		// the caller's context was canceled
+		return ctx.Err()
+	}
+
+	return firstErr
+}
@@ -0,0 +1,94 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResultsKeepTheOrderOfTheInput(t *testing.T) {
+	results := make([]int, 50)
+	err := Run(context.Background(), 4, len(results), func(ctx context.Context, i int) error {
+		// later pieces of work finish first
+		time.Sleep(time.Duration(len(results)-i) * 100 * time.Microsecond)
+		results[i] = i * i
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	for i, r := range results {
+		if r != i*i {
+			t.Fatalf("Expected %d at index %d, got %d", i*i, i, r)
+		}
+	}
+}
+
+func TestAtMostSizeRunAtATime(t *testing.T) {
+	var running, most int32
+	err := Run(context.Background(), 3, 20, func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if most > 3 {
+		t.Errorf("Expected at most 3 pieces of work at a time, got %d", most)
+	}
+}
+
+func TestFirstErrorStopsTheRest(t *testing.T) {
+	first := errors.New("first")
+	var started int32
+	err := Run(context.Background(), 2, 100, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&started, 1)
+		switch i {
+		case 0:
+			return first
+		case 1:
+			// running work sees the cancel, its error comes after the first one
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	if err != first {
+		t.Errorf("Expected the first error, got %v", err)
+	}
+	if started == 100 {
+		t.Errorf("Expected the work that hadn't started to be skipped")
+	}
+}
+
+func TestCallerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var started int32
+	err := Run(ctx, 2, 100, func(ctx context.Context, i int) error {
+		if atomic.AddInt32(&started, 1) == 1 {
+			cancel()
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	if err != context.Canceled {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+	if started == 100 {
+		t.Errorf("Expected the work that hadn't started to be skipped")
+	}
+}
@@ -0,0 +1,228 @@
+// Package repoconfig holds the settings that can differ per installation and per repo, like where failures are sent
+//
+// The settings are one JSON document, kept in the RepoConfig SSM parameter since it holds webhook URLs and secrets:
+//
+//	{
+//	  "defaults": {"slack": {"webhook_url": "https://hooks.slack.com/services/..."}, "slack_users": {"octocat": "U024BE7LH"}},
+//	  "installations": {"1234": {"webhooks": [{"url": "https://example.com/ci", "secret": "..."}]}, "5678": {"dry_run": true}},
+//	  "repos": {"codingdiaz/circleci-feedback": {"slack": {"webhook_url": "https://hooks.slack.com/services/..."}}},
+//	  "authors": {"octocat": {"slack": {"webhook_url": "https://hooks.slack.com/services/..."}}}
+//	}
+package repoconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// File is the whole settings document
+type File struct {
+	Defaults      Settings            `json:"defaults"`
+	Installations map[string]Settings `json:"installations"` // by installation ID
+	Repos         map[string]Settings `json:"repos"`         // by owner/repo
+	Authors       map[string]Route    `json:"authors"`       // by GitHub login of the pull request author
+}
+
+// Settings for an installation or a repo, a setting that is left out is inherited
+// Repos inherit from their installation, installations from the defaults, "webhooks": [] turns inherited webhooks off
+type Settings struct {
+	Slack    *Slack    `json:"slack,omitempty"`
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+	// MentionCodeOwners mentions the code owners of the files of failing tests in the report, off by default
+	MentionCodeOwners *bool `json:"mention_codeowners,omitempty"`
+	// SlackUsers maps GitHub logins to Slack user IDs so Slack messages mention people, unlike the other
+	// settings they add to the inherited ones rather than replace them
+	SlackUsers map[string]string `json:"slack_users,omitempty"`
+	// DryRun keeps the comments the app would post on GitHub instead of posting them, to see what it does on a
+	// new installation before turning it on, off by default
+	DryRun *bool `json:"dry_run,omitempty"`
+}
+
+// Route sends the failures of the pull requests of an author somewhere else than the repo does, like their own
+// Slack channel, a destination that is left out is the one of the repo and "webhooks": [] turns the repo's off
+type Route struct {
+	Slack    *Slack    `json:"slack,omitempty"`
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+}
+
+// Slack sends failures to the channel of a Slack incoming webhook, an empty WebhookURL turns inherited Slack settings off
+type Slack struct {
+	WebhookURL string `json:"webhook_url"`
+}
+
+// Webhook sends failures as JSON to URL, signed with Secret
+type Webhook struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// Parse reads and validates a settings document, unknown fields are an error so typos don't go unnoticed
+func Parse(b []byte) (*File, error) {
+	f := &File{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing repo config, error: %s", err)
+	}
+
+	err = f.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Validate checks every installation ID, repo name and URL in the document
+func (f *File) Validate() error {
+	err := f.Defaults.validate("defaults")
+	if err != nil {
+		return err
+	}
+
+	for id, s := range f.Installations {
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			return fmt.Errorf("installation %q: installations are keyed by their numeric ID", id)
+		}
+		err = s.validate("installation " + id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for repo, s := range f.Repos {
+		if parts := strings.Split(repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("repo %q: repos are keyed by owner/repo", repo)
+		}
+		err = s.validate("repo " + repo)
+		if err != nil {
+			return err
+		}
+	}
+
+	for login, r := range f.Authors {
+		if login == "" || strings.ContainsAny(login, "@/ ") {
+			return fmt.Errorf("author %q: authors are keyed by GitHub login", login)
+		}
+		err = Settings{Slack: r.Slack, Webhooks: r.Webhooks}.validate("author " + login)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s Settings) validate(where string) error {
+	if s.Slack != nil && s.Slack.WebhookURL != "" {
+		err := validateURL(s.Slack.WebhookURL)
+		if err != nil {
+			return fmt.Errorf("%s: slack webhook_url %s", where, err)
+		}
+	}
+
+	for login, id := range s.SlackUsers {
+		if login == "" || id == "" {
+			return fmt.Errorf("%s: slack_users maps GitHub logins to Slack user IDs, got %q: %q", where, login, id)
+		}
+	}
+
+	for i, w := range s.Webhooks {
+		err := validateURL(w.URL)
+		if err != nil {
+			return fmt.Errorf("%s: webhook %d url %s", where, i, err)
+		}
+		if w.Secret == "" {
+			return fmt.Errorf("%s: webhook %d needs a secret to sign its payloads", where, i)
+		}
+	}
+
+	return nil
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%q isn't an http(s) URL", raw)
+	}
+
+	return nil
+}
+
+// For returns the settings of a repo (owner/repo) of an installation
+// A nil File, when no settings are configured, has empty settings for every repo
+func (f *File) For(installationID int64, repo string) Settings {
+	if f == nil {
+		return Settings{}
+	}
+
+	s := f.Defaults
+	s = s.merge(f.Installations[strconv.FormatInt(installationID, 10)])
+	s = s.merge(f.Repos[repo])
+
+	return s
+}
+
+// ForAuthor returns the settings of a repo for a pull request opened by author, with the destinations of the route
+// of the author in place of the ones of the repo
+// Logins are compared case insensitively like GitHub does
+func (f *File) ForAuthor(installationID int64, repo, author string) Settings {
+	s := f.For(installationID, repo)
+	if f == nil || author == "" {
+		return s
+	}
+
+	for login, r := range f.Authors {
+		if strings.EqualFold(login, author) {
+			return s.merge(Settings{Slack: r.Slack, Webhooks: r.Webhooks})
+		}
+	}
+
+	return s
+}
+
+// merge returns s with the settings o sets
+func (s Settings) merge(o Settings) Settings {
+	if o.Slack != nil {
+		s.Slack = o.Slack
+	}
+	if o.Webhooks != nil {
+		s.Webhooks = o.Webhooks
+	}
+	if o.MentionCodeOwners != nil {
+		s.MentionCodeOwners = o.MentionCodeOwners
+	}
+	if o.DryRun != nil {
+		s.DryRun = o.DryRun
+	}
+	if len(o.SlackUsers) > 0 {
+		users := map[string]string{}
+		for login, id := range s.SlackUsers {
+			users[login] = id
+		}
+		for login, id := range o.SlackUsers {
+			users[login] = id
+		}
+		s.SlackUsers = users
+	}
+
+	return s
+}
+
+// CodeOwners reports whether code owners are mentioned in reports
+func (s Settings) CodeOwners() bool {
+	return s.MentionCodeOwners != nil && *s.MentionCodeOwners
+}
+
+// DryRunEnabled reports whether comments are kept instead of posted, see the dryrun package
+func (s Settings) DryRunEnabled() bool {
+	return s.DryRun != nil && *s.DryRun
+}
@@ -0,0 +1,36 @@
+package repoconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuthorRoutes(t *testing.T) {
+	f, err := Parse([]byte(`{
+		"defaults": {"slack": {"webhook_url": "https://hooks.slack.com/services/repo"}, "webhooks": [{"url": "https://example.com/ci", "secret": "s"}]},
+		"authors": {"OctoCat": {"slack": {"webhook_url": "https://hooks.slack.com/services/octocat"}}}
+	}`))
+	if err != nil {
+		t.Fatalf("Expected the config to parse, got %s", err)
+	}
+
+	s := f.ForAuthor(1, "octo/app", "octocat")
+	if s.Slack.WebhookURL != "https://hooks.slack.com/services/octocat" {
+		t.Errorf("Expected the author's Slack webhook, got %s", s.Slack.WebhookURL)
+	}
+	if len(s.Webhooks) != 1 {
+		t.Errorf("Expected the webhooks the author leaves out to be the repo's, got %+v", s.Webhooks)
+	}
+
+	s = f.ForAuthor(1, "octo/app", "hubot")
+	if s.Slack.WebhookURL != "https://hooks.slack.com/services/repo" {
+		t.Errorf("Expected other authors to get the repo's Slack webhook, got %s", s.Slack.WebhookURL)
+	}
+}
+
+func TestAuthorRoutesAreValidated(t *testing.T) {
+	_, err := Parse([]byte(`{"authors": {"octocat": {"webhooks": [{"url": "https://example.com/ci"}]}}}`))
+	if err == nil || !strings.Contains(err.Error(), "author octocat") {
+		t.Errorf("Expected an error about the webhook of octocat, got %v", err)
+	}
+}
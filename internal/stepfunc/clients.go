@@ -0,0 +1,152 @@
+package stepfunc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+)
+
+// ConfigSource reads the configuration of the functions, it is read again for every invocation
+type ConfigSource interface {
+	Config() (Config, error)
+}
+
+// SSMConfig reads the configuration from SSM and the environment with GetConfiguration
+type SSMConfig struct{}
+
+// Config reads the configuration with GetConfiguration
+func (SSMConfig) Config() (Config, error) {
+	return GetConfiguration()
+}
+
+// EnvConfig reads the configuration from the environment alone, for running the functions locally without SSM
+// The secrets SSM holds come from these variables instead, the ones that aren't set are left empty
+//
+//	GITHUB_WEBHOOK_SECRET        the webhook secret
+//	GITHUB_APP_ID                the ID of the GitHub App
+//	GITHUB_APP_PRIVATE_KEY_FILE  a file with the PEM encoded private key of the GitHub App
+//	CIRCLE_TOKEN                 a CircleCI API token
+//	REPO_CONFIG_FILE             a file with the settings per installation and repo
+type EnvConfig struct{}
+
+// Config reads the configuration from the environment
+func (EnvConfig) Config() (Config, error) {
+	config := Config{
+		GitHubWebhookSecret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
+		CircleToken:         os.Getenv("CIRCLE_TOKEN"),
+	}
+
+	err := getHostConfiguration(&config)
+	if err != nil {
+		return config, err
+	}
+
+	if v := os.Getenv("ALLOW_SHA1_SIGNATURES"); v != "" {
+		config.AllowSHA1Signatures, err = strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("Error parsing ALLOW_SHA1_SIGNATURES, error: %s", err)
+		}
+	}
+
+	if v := os.Getenv("GITHUB_APP_ID"); v != "" {
+		config.AppID, err = strconv.Atoi(v)
+		if err != nil {
+			return config, fmt.Errorf("Error parsing GITHUB_APP_ID, error: %s", err)
+		}
+	}
+
+	if path := os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE"); path != "" {
+		config.GithubAppPrivateKey, err = ioutil.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("Error reading GITHUB_APP_PRIVATE_KEY_FILE, error: %s", err)
+		}
+	}
+
+	if path := os.Getenv("REPO_CONFIG_FILE"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("Error reading REPO_CONFIG_FILE, error: %s", err)
+		}
+		config.Repos, err = repoconfig.Parse(b)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	return config, nil
+}
+
+// StaticConfig is a configuration that was read once, like in tests
+type StaticConfig Config
+
+// Config returns c
+func (c StaticConfig) Config() (Config, error) {
+	return Config(c), nil
+}
+
+// CircleCIClients hands out the CircleCI clients of an invocation
+type CircleCIClients interface {
+	// Client returns a client of the CircleCI host of c, its requests are made with ctx, logged with logger and traced under the span in ctx
+	Client(ctx context.Context, c Config, logger *slog.Logger) circleci.API
+}
+
+// CircleCI hands out clients of the real CircleCI API, the GET responses of the clients of an invocation are
+// cached and revalidated with their ETag by the clients of the next one rather than fetched again
+type CircleCI struct {
+	mu    sync.Mutex
+	cache *circleci.Cache
+}
+
+// Client returns a client with the shared cache, responses cached by the clients of previous invocations are revalidated first
+func (f *CircleCI) Client(ctx context.Context, c Config, logger *slog.Logger) circleci.API {
+	f.mu.Lock()
+	if f.cache == nil {
+		f.cache = circleci.NewCache()
+	}
+	f.cache.Reset()
+	cache := f.cache
+	f.mu.Unlock()
+
+	client := &circleci.Client{
+		BaseURL:    circleci.BaseURL(c.CircleHost),
+		Token:      c.CircleToken,
+		Cache:      cache,
+		Logger:     logger,
+		HTTPClient: HTTPClient(ctx, "circleci"),
+	}
+	return client.WithContext(ctx)
+}
+
+// GitHubClients hands out the GitHub API of installations of the app, the client factory is made from the
+// configuration the first time an installation is asked for and kept so installation tokens are reused
+type GitHubClients struct {
+	Config ConfigSource
+
+	mu      sync.Mutex
+	factory *githubapp.ClientFactory
+}
+
+// Installation returns the API of installationID
+func (g *GitHubClients) Installation(installationID int64) (githubapp.API, error) {
+	g.mu.Lock()
+	if g.factory == nil {
+		c, err := g.Config.Config()
+		if err != nil {
+			g.mu.Unlock()
+			return nil, err
+		}
+		g.factory = c.GitHubClientFactory()
+	}
+	factory := g.factory
+	g.mu.Unlock()
+
+	return factory.Installation(installationID)
+}
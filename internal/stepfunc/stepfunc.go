@@ -2,36 +2,188 @@
 package stepfunc
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/codingdiaz/circleci-feedback/internal/blob"
+	"github.com/codingdiaz/circleci-feedback/internal/logging"
+	"github.com/codingdiaz/circleci-feedback/internal/metrics"
+	"github.com/codingdiaz/circleci-feedback/internal/pool"
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+	"github.com/codingdiaz/circleci-feedback/internal/tracing"
 	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
 )
 
 // Data is the common input/ouput for all lambda functions in the step function
 type Data struct {
-	RepoName              string                    `json:"repo_name"`
-	Owner                 string                    `json:"owner"`
-	PullRequestNumber     int                       `json:"pull_request_number"`
-	InstallationID        int                       `json:"installation_id"`
-	CommitSHA             string                    `json:"commit_sha"`
-	PipelineID            string                    `json:"pipeline_id"`
-	WorkflowIDs           []string                  `json:"workflow_ids"`
-	WorkflowJobs          map[string][]circleci.Job `json:"workflow_jobs"`
-	AllJobsDone           bool                      `json:"all_jobs_done"`
-	WaitForJobsRetryCount int                       `json:"wait_for_jobs_retry_count"`
-	WaitForJobsWaitTime   int                       `json:"wait_for_jobs_wait_time"`
+	// CorrelationID is the X-GitHub-Delivery ID of the webhook that started the execution, it is in every log line
+	CorrelationID string `json:"correlation_id"`
+	// TraceParent is the W3C traceparent of the span of the webhook, every task is traced as its child
+	TraceParent       string `json:"trace_parent,omitempty"`
+	GitHubHost        string `json:"github_host"`
+	RepoName          string `json:"repo_name"`
+	Owner             string `json:"owner"`
+	PullRequestNumber int    `json:"pull_request_number"`
+	Author            string `json:"author"` // login of who opened the pull request, mentioned in the report
+	InstallationID    int    `json:"installation_id"`
+	CommitSHA         string `json:"commit_sha"`
+	Branch            string `json:"branch"`
+	BaseBranch        string `json:"base_branch"`
+	PipelineID        string `json:"pipeline_id"`
+	// PipelineFound is false while CircleCI hasn't created the pipeline yet, the state machine waits
+	// FindPipelineWaitTime seconds and looks again until PipelineSearchTimedOut
+	PipelineFound           bool      `json:"pipeline_found"`
+	PipelineSearchStartedAt time.Time `json:"pipeline_search_started_at"`
+	PipelineSearchTimedOut  bool      `json:"pipeline_search_timed_out"`
+	FindPipelineWaitTime    int       `json:"find_pipeline_wait_time"`
+	WorkflowIDs             []string  `json:"workflow_ids"`
+	// Jobs summarizes the jobs of the watched workflows, the jobs themselves are too big for the state
+	Jobs                  JobsSummary `json:"jobs"`
+	AllJobsDone           bool        `json:"all_jobs_done"`
+	WaitForJobsRetryCount int         `json:"wait_for_jobs_retry_count"`
+	WaitForJobsWaitTime   int         `json:"wait_for_jobs_wait_time"`
+	WaitForJobsStartedAt  time.Time   `json:"wait_for_jobs_started_at"`
+	GaveUp                bool        `json:"gave_up"`
+	// failures are reported while the pipeline runs, ReportedJobs are the IDs of the failed jobs
+	// already in the report comment ReportCommentID, which is edited as more jobs fail
+	ReportedJobs    []string `json:"reported_jobs"`
+	ReportCommentID int64    `json:"report_comment_id"`
+}
+
+// Logger returns the default logger with the fields identifying the pull request and pipeline the execution watches
+func (d Data) Logger() *slog.Logger {
+	return slog.Default().With(
+		logging.CorrelationID, d.CorrelationID,
+		logging.Owner, d.Owner,
+		logging.Repo, d.RepoName,
+		logging.PullRequest, d.PullRequestNumber,
+		logging.SHA, d.CommitSHA,
+		logging.PipelineID, d.PipelineID,
+	)
+}
+
+// StartSpan starts the span of a step function task in the trace of the webhook that started the execution
+func (d Data) StartSpan(ctx context.Context, name string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(tracing.WithTraceParent(ctx, d.TraceParent), name, tracing.Server)
+	span.SetAttribute(logging.CorrelationID, d.CorrelationID)
+	span.SetAttribute(logging.Owner, d.Owner)
+	span.SetAttribute(logging.Repo, d.RepoName)
+	span.SetAttribute(logging.PullRequest, d.PullRequestNumber)
+	span.SetAttribute(logging.SHA, d.CommitSHA)
+	span.SetAttribute(logging.PipelineID, d.PipelineID)
+	return ctx, span
+}
+
+// JobsSummary is the compact form of the jobs of the watched workflows kept in the state,
+// the jobs themselves are kept in a blob store under Key
+type JobsSummary struct {
+	Total  int `json:"total"`
+	Done   int `json:"done"`
+	Failed int `json:"failed"`
+	// Digest is the sha256 of the stored jobs, they are only stored again when it changes
+	Digest string `json:"digest"`
+	Key    string `json:"key"`
+}
+
+// SaveJobs summarizes the jobs of every watched workflow (by workflow ID) in d.Jobs and keeps them in store
+// The counts are updated even when storing the jobs fails, Key then still points at the jobs stored last
+func (d *Data) SaveJobs(store blob.Store, jobs map[string][]circleci.Job) error {
+	summary := JobsSummary{Digest: d.Jobs.Digest, Key: d.Jobs.Key}
+	for _, workflowJobs := range jobs {
+		for _, job := range workflowJobs {
+			summary.Total++
+			if circleci.JobDone(job, workflowJobs) {
+				summary.Done++
+			}
+			if job.Status == "failed" {
+				summary.Failed++
+			}
+		}
+	}
+	d.Jobs = summary
+
+	b, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+
+	digest := fmt.Sprintf("%x", sha256.Sum256(b))
+	if digest == d.Jobs.Digest {
+		return nil
+	}
+
+	// the key is the digest, jobs that didn't change since the last poll are never stored twice
+	key := "jobs/" + digest + ".json"
+	err = store.Put(key, b)
+	if err != nil {
+		return fmt.Errorf("Error storing the jobs of pipeline %s, error: %s", d.PipelineID, err)
+	}
+
+	d.Jobs.Digest = digest
+	d.Jobs.Key = key
+	return nil
+}
+
+// LoadJobs returns the jobs stored by the last SaveJobs, by workflow ID
+func (d Data) LoadJobs(store blob.Store) (map[string][]circleci.Job, error) {
+	jobs := map[string][]circleci.Job{}
+	if d.Jobs.Key == "" {
+		return jobs, nil
+	}
+
+	b, err := store.Get(d.Jobs.Key)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading the jobs of pipeline %s, error: %s", d.PipelineID, err)
+	}
+
+	err = json.Unmarshal(b, &jobs)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the jobs of pipeline %s, error: %s", d.PipelineID, err)
+	}
+
+	return jobs, nil
 }
 
 // Config holds all the configuration for the lambda function
 type Config struct {
 	GitHubWebhookSecret string
+	// GitHubWebhookSecretPrevious is also accepted while rotating the webhook secret, it is empty when not rotating
+	GitHubWebhookSecretPrevious string
+	// AllowSHA1Signatures accepts webhooks only signed with the legacy X-Hub-Signature header
+	AllowSHA1Signatures bool
 	GithubAppPrivateKey []byte
-	InstallationID      int
-	CircleToken         string
+	// AppID is the ID of the GitHub App, installation IDs come from each webhook so one deployment serves every installation
+	AppID       int
+	CircleToken string
+
+	// GitHubHost is the host webhooks are accepted from, github.com unless the app runs on GitHub Enterprise Server
+	GitHubHost string
+	// GitHubBaseURL and GitHubUploadURL point the GitHub client at GitHub Enterprise Server, they are empty for github.com
+	GitHubBaseURL   string
+	GitHubUploadURL string
+	// CircleHost is the CircleCI host, circleci.com unless the projects are on a CircleCI server install
+	CircleHost string
+	// CircleVCS is the VCS type used in CircleCI project slugs
+	CircleVCS string
+	// CircleConcurrency is how many CircleCI requests and output downloads a function makes at a time
+	CircleConcurrency int
+
+	// Repos are the settings per installation and repo, nil when none are configured
+	Repos *repoconfig.File
 }
 
 // GetConfiguration gets all the secret values that the lambda function needs to run and will error if it can't fetch any
@@ -55,6 +207,30 @@ func GetConfiguration() (Config, error) {
 
 	config.GitHubWebhookSecret = *param.Parameter.Value
 
+	// the previous secret is optional, it only exists while the webhook secret is being rotated
+	keyname = "/circleci-feedback/GithubWebhookSecretPrevious"
+	param, err = ssmsvc.GetParameter(&ssm.GetParameterInput{
+		Name:           &keyname,
+		WithDecryption: &withDecryption,
+	})
+	if err == nil {
+		config.GitHubWebhookSecretPrevious = *param.Parameter.Value
+	} else if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != ssm.ErrCodeParameterNotFound {
+		return config, fmt.Errorf("Error getting GithubWebhookSecretPrevious, error: %s", err)
+	}
+
+	err = getHostConfiguration(&config)
+	if err != nil {
+		return config, err
+	}
+
+	if v := os.Getenv("ALLOW_SHA1_SIGNATURES"); v != "" {
+		config.AllowSHA1Signatures, err = strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("Error parsing ALLOW_SHA1_SIGNATURES, error: %s", err)
+		}
+	}
+
 	keyname = "/circleci-feedback/GithubAppPrivateKey"
 	param, err = ssmsvc.GetParameter(&ssm.GetParameterInput{
 		Name:           &keyname,
@@ -66,20 +242,28 @@ func GetConfiguration() (Config, error) {
 
 	config.GithubAppPrivateKey = []byte(*param.Parameter.Value)
 
-	keyname = "/circleci-feedback/InstallationID"
+	// the app id used to be stored as InstallationID, fall back to it so existing deployments keep working
+	keyname = "/circleci-feedback/AppID"
 	param, err = ssmsvc.GetParameter(&ssm.GetParameterInput{
 		Name:           &keyname,
 		WithDecryption: &withDecryption,
 	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		keyname = "/circleci-feedback/InstallationID"
+		param, err = ssmsvc.GetParameter(&ssm.GetParameterInput{
+			Name:           &keyname,
+			WithDecryption: &withDecryption,
+		})
+	}
 	if err != nil {
-		return config, fmt.Errorf("Error getting InstallationID, error: %s", err)
+		return config, fmt.Errorf("Error getting AppID, error: %s", err)
 	}
-	InstallationID, err := strconv.Atoi(*param.Parameter.Value)
+	AppID, err := strconv.Atoi(*param.Parameter.Value)
 	if err != nil {
-		return config, fmt.Errorf("Error converting InstallationID to int from string, error: %s", err)
+		return config, fmt.Errorf("Error converting AppID to int from string, error: %s", err)
 	}
 
-	config.InstallationID = InstallationID
+	config.AppID = AppID
 
 	keyname = "/circleci-feedback/CircleToken"
 	param, err = ssmsvc.GetParameter(&ssm.GetParameterInput{
@@ -87,11 +271,112 @@ func GetConfiguration() (Config, error) {
 		WithDecryption: &withDecryption,
 	})
 	if err != nil {
-		return config, fmt.Errorf("Error getting InstallationID, error: %s", err)
+		return config, fmt.Errorf("Error getting CircleToken, error: %s", err)
 	}
 
 	config.CircleToken = *param.Parameter.Value
 
+	config.Repos, err = loadRepoConfig(ssmsvc)
+	if err != nil {
+		return config, err
+	}
+
 	return config, nil
 
 }
+
+// loadRepoConfig reads the settings per installation and repo from the file REPO_CONFIG_FILE points at,
+// or otherwise from the optional RepoConfig parameter
+func loadRepoConfig(ssmsvc *ssm.SSM) (*repoconfig.File, error) {
+	if path := os.Getenv("REPO_CONFIG_FILE"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading REPO_CONFIG_FILE, error: %s", err)
+		}
+		return repoconfig.Parse(b)
+	}
+
+	keyname := "/circleci-feedback/RepoConfig"
+	withDecryption := true
+	param, err := ssmsvc.GetParameter(&ssm.GetParameterInput{
+		Name:           &keyname,
+		WithDecryption: &withDecryption,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error getting RepoConfig, error: %s", err)
+	}
+
+	return repoconfig.Parse([]byte(*param.Parameter.Value))
+}
+
+// GitHubClientFactory returns a client factory for the GitHub App on the configured GitHub host
+func (c Config) GitHubClientFactory() *githubapp.ClientFactory {
+	f := githubapp.NewClientFactory(c.AppID, c.GithubAppPrivateKey)
+	f.BaseURL = c.GitHubBaseURL
+	f.UploadURL = c.GitHubUploadURL
+	f.Transport = &tracing.Transport{Base: &metrics.Transport{Service: "github"}}
+	return f
+}
+
+// HTTPClient returns the client for requests to service, they are counted in the metrics and traced as children of the span in ctx
+func HTTPClient(ctx context.Context, service string) *http.Client {
+	return &http.Client{Transport: &tracing.Transport{Base: &metrics.Transport{Service: service}, Context: ctx}}
+}
+
+// DurationFromEnv reads a Go duration from the environment variable name, or returns def when it is not set
+func DurationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing %s, error: %s", name, err)
+	}
+
+	return d, nil
+}
+
+// getHostConfiguration reads which GitHub and CircleCI hosts and CircleCI VCS type this deployment serves, and how hard
+// it may hit the CircleCI API, from the environment
+func getHostConfiguration(config *Config) error {
+	config.GitHubBaseURL = os.Getenv("GITHUB_BASE_URL")
+	config.GitHubUploadURL = os.Getenv("GITHUB_UPLOAD_URL")
+
+	config.GitHubHost = os.Getenv("GITHUB_HOST")
+	if config.GitHubHost == "" {
+		config.GitHubHost = githubapp.DefaultHost
+		if config.GitHubBaseURL != "" {
+			u, err := url.Parse(config.GitHubBaseURL)
+			if err != nil {
+				return fmt.Errorf("Error parsing GITHUB_BASE_URL, error: %s", err)
+			}
+			config.GitHubHost = u.Hostname()
+		}
+	}
+
+	config.CircleHost = os.Getenv("CIRCLECI_HOST")
+	if config.CircleHost == "" {
+		config.CircleHost = circleci.DefaultHost
+	}
+
+	vcs, err := circleci.VCSSlug(os.Getenv("CIRCLECI_VCS"))
+	if err != nil {
+		return fmt.Errorf("Error parsing CIRCLECI_VCS, error: %s", err)
+	}
+	config.CircleVCS = vcs
+
+	config.CircleConcurrency = pool.DefaultSize
+	if v := os.Getenv("CIRCLECI_CONCURRENCY"); v != "" {
+		config.CircleConcurrency, err = strconv.Atoi(v)
+		if err != nil || config.CircleConcurrency < 1 {
+			return fmt.Errorf("Error parsing CIRCLECI_CONCURRENCY, it has to be a positive number, got %s", v)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,3 @@
+// Package e2e runs the functions the way the step function does, from a webhook to the entry function through
+// find_pipeline_id and wait_for_jobs, against the fake CircleCI and GitHub servers, and checks what they comment
+package e2e
@@ -0,0 +1,363 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/codingdiaz/circleci-feedback/internal/blob"
+	"github.com/codingdiaz/circleci-feedback/internal/dedup"
+	"github.com/codingdiaz/circleci-feedback/internal/entry"
+	"github.com/codingdiaz/circleci-feedback/internal/findpipeline"
+	"github.com/codingdiaz/circleci-feedback/internal/flaky"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakecircle"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakegithub"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakes"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/harness"
+	"github.com/codingdiaz/circleci-feedback/internal/waitforjobs"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+const (
+	secret = "webhook-secret"
+	owner  = "octo"
+	repo   = "app"
+	sha    = "0123456789abcdef0123456789abcdef01234567"
+)
+
+// env is a deployment of the functions against fake servers
+type env struct {
+	t      *testing.T
+	circle *fakecircle.Server
+	github *fakegithub.Server
+	entry  *entry.Handler
+
+	config     stepfunc.StaticConfig
+	executions fakes.Executions
+}
+
+func newEnv(t *testing.T) *env {
+	e := &env{t: t, circle: fakecircle.NewServer(t), github: fakegithub.NewServer(t)}
+	harness.Install(t, map[string]string{
+		fakecircle.Host: e.circle.URL,
+		fakegithub.Host: e.github.URL,
+	})
+
+	e.config = stepfunc.StaticConfig{
+		GitHubWebhookSecret: secret,
+		GithubAppPrivateKey: fakegithub.PrivateKey(t),
+		AppID:               1,
+		CircleToken:         "circle-token",
+		GitHubHost:          "github.com",
+		CircleVCS:           "gh",
+		CircleConcurrency:   2,
+	}
+
+	e.entry = &entry.Handler{
+		Deliveries: dedup.NewMemoryStore(),
+		Config:     e.config,
+		GitHub:     &stepfunc.GitHubClients{Config: e.config},
+		CircleCI:   &stepfunc.CircleCI{},
+		Executions: &e.executions,
+	}
+
+	return e
+}
+
+// roundTrip passes data through JSON like the step function passes it between tasks
+func roundTrip(t *testing.T, in stepfunc.Data) stepfunc.Data {
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Error encoding the step function data, error: %s", err)
+	}
+
+	out := stepfunc.Data{}
+	err = json.Unmarshal(b, &out)
+	if err != nil {
+		t.Fatalf("Error decoding the step function data, error: %s", err)
+	}
+	return out
+}
+
+// webhook sends a webhook to the entry function, it has to be handled
+func (e *env) webhook(event, delivery string, payload interface{}) {
+	e.t.Helper()
+
+	response, err := e.entry.Handle(context.Background(), fakegithub.Webhook(e.t, event, delivery, secret, payload))
+	if err != nil {
+		e.t.Fatalf("Error handling the %s webhook, error: %s", event, err)
+	}
+	if response.StatusCode != 200 {
+		e.t.Fatalf("The %s webhook was answered with %d", event, response.StatusCode)
+	}
+}
+
+// run runs an execution to its end, the waits of the step function are skipped
+func (e *env) run(in stepfunc.Data) stepfunc.Data {
+	e.t.Helper()
+
+	in = roundTrip(e.t, in)
+	find := &findpipeline.Handler{Config: e.config, CircleCI: &stepfunc.CircleCI{}}
+	for i := 0; !in.PipelineFound; i++ {
+		if i == 10 || in.PipelineSearchTimedOut {
+			e.t.Fatalf("The pipeline for %s was never found", in.CommitSHA)
+		}
+
+		out, err := find.Handle(context.Background(), in)
+		if err != nil {
+			e.t.Fatalf("Error finding the pipeline, error: %s", err)
+		}
+		in = roundTrip(e.t, out)
+	}
+
+	wait := &waitforjobs.Handler{
+		History:  flaky.NewMemoryStore(),
+		Blobs:    blob.NewMemoryStore(),
+		Config:   e.config,
+		GitHub:   &stepfunc.GitHubClients{Config: e.config},
+		CircleCI: &stepfunc.CircleCI{},
+	}
+	for i := 0; !in.AllJobsDone; i++ {
+		if i == 20 {
+			e.t.Fatalf("The jobs of pipeline %s never finished", in.PipelineID)
+		}
+
+		out, err := wait.Handle(context.Background(), in)
+		if err != nil {
+			e.t.Fatalf("Error waiting for the jobs, error: %s", err)
+		}
+		in = roundTrip(e.t, out)
+	}
+
+	return in
+}
+
+// comments returns the bodies of the comments on pull request 7
+func (e *env) comments() []string {
+	bodies := []string{}
+	for _, c := range e.github.Comments(owner, repo, 7) {
+		bodies = append(bodies, c.Body)
+	}
+	return bodies
+}
+
+func pullRequestEvent(action string) map[string]interface{} {
+	return map[string]interface{}{
+		"action":       action,
+		"number":       7,
+		"installation": map[string]interface{}{"id": 42},
+		"repository": map[string]interface{}{
+			"name":  repo,
+			"owner": map[string]interface{}{"login": owner},
+		},
+		"pull_request": map[string]interface{}{
+			"number": 7,
+			"user":   map[string]interface{}{"login": "mona"},
+			"head":   map[string]interface{}{"ref": "feature", "sha": sha},
+			"base":   map[string]interface{}{"ref": "main"},
+		},
+	}
+}
+
+func commentEvent(login, body string) map[string]interface{} {
+	return map[string]interface{}{
+		"action":       "created",
+		"installation": map[string]interface{}{"id": 42},
+		"repository": map[string]interface{}{
+			"name":  repo,
+			"owner": map[string]interface{}{"login": owner},
+		},
+		"issue": map[string]interface{}{
+			"number":       7,
+			"pull_request": map[string]interface{}{"url": "https://api.github.com/repos/octo/app/pulls/7"},
+		},
+		"comment": map[string]interface{}{"body": body},
+		"sender":  map[string]interface{}{"login": login, "type": "User"},
+	}
+}
+
+// failingPipeline is a pipeline for sha whose test job fails after running once
+func failingPipeline() *fakecircle.Pipeline {
+	return &fakecircle.Pipeline{
+		ID:        "pipeline-1",
+		Slug:      "gh/" + owner + "/" + repo,
+		Number:    12,
+		Branch:    "feature",
+		Revision:  sha,
+		HiddenFor: 2,
+		Workflows: []*fakecircle.Workflow{{
+			ID:   "workflow-1",
+			Name: "build",
+			Jobs: []*fakecircle.Job{
+				{ID: "job-lint", Name: "lint", Number: 100, Statuses: []string{"running", "success"}},
+				{
+					ID:       "job-test",
+					Name:     "test",
+					Number:   101,
+					Statuses: []string{"running", "running", "failed"},
+					Steps: []fakecircle.Step{
+						{Name: "Checkout code", Actions: []fakecircle.Action{{Status: "success", Output: "cloned"}}},
+						{Name: "go test ./...", Actions: []fakecircle.Action{{Status: "failed", Output: "--- FAIL: TestAdd\nexpected 3, got 4\nFAIL"}}},
+					},
+					Tests: []circleci.TestResult{
+						{Name: "TestAdd", Classname: "pkg/math", Result: "failure", Message: "expected 3, got 4", File: "pkg/math/add_test.go"},
+					},
+				},
+			},
+		}},
+	}
+}
+
+// expectComments fails the test unless the pull request has exactly the comments want
+func (e *env) expectComments(want ...string) {
+	e.t.Helper()
+
+	got := e.comments()
+	if len(got) != len(want) {
+		e.t.Fatalf("Expected %d comments, got %d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			e.t.Errorf("Comment %d is\n%s\nexpected\n%s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFailureReport(t *testing.T) {
+	e := newEnv(t)
+	e.github.AddFile(owner, repo, ".circleci/config.yml", "version: 2.1\n")
+	e.circle.AddPipeline(failingPipeline())
+
+	e.webhook("pull_request", "delivery-1", pullRequestEvent("synchronize"))
+	if len(e.executions.Started()) != 1 {
+		t.Fatalf("Expected the webhook to start 1 execution, it started %d", len(e.executions.Started()))
+	}
+
+	out := e.run(e.executions.Started()[0].Input)
+	if out.PipelineID != "pipeline-1" {
+		t.Errorf("Expected the execution to watch pipeline-1, it watched %q", out.PipelineID)
+	}
+
+	e.expectComments("<!-- circleci-feedback report -->\n" +
+		"@mona **CircleCI:** 1 failed job\n" +
+		"<!-- circleci-feedback failure -->\n" +
+		"Build Failed :cry: `test`\n" +
+		"\n" +
+		"Failed tests:\n" +
+		"* `pkg/math.TestAdd`\n" +
+		"\n" +
+		"```\n" +
+		"--- FAIL: TestAdd\n" +
+		"expected 3, got 4\n" +
+		"FAIL\n" +
+		"```\n")
+}
+
+func TestRedeliveredWebhook(t *testing.T) {
+	e := newEnv(t)
+	e.github.AddFile(owner, repo, ".circleci/config.yml", "version: 2.1\n")
+	e.circle.AddPipeline(failingPipeline())
+
+	e.webhook("pull_request", "delivery-1", pullRequestEvent("synchronize"))
+	e.webhook("pull_request", "delivery-1", pullRequestEvent("synchronize"))
+	if len(e.executions.Started()) != 1 {
+		t.Fatalf("Expected the redelivered webhook to start 1 execution, it started %d", len(e.executions.Started()))
+	}
+}
+
+func TestMissingConfig(t *testing.T) {
+	e := newEnv(t)
+
+	e.webhook("pull_request", "delivery-1", pullRequestEvent("opened"))
+
+	e.expectComments("You don't seem to have a .circleci/config.yml file in your repo\n Register with CircleCI to use this GITHUB APP.")
+}
+
+func TestGitHubUnavailable(t *testing.T) {
+	e := newEnv(t)
+	fixtures, err := harness.LoadFixtures("testdata/contents_unavailable.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.github.Replay.Add(fixtures...)
+
+	// GitHub retries the delivery after the entry function failed it, the retry is handled
+	request := fakegithub.Webhook(t, "pull_request", "delivery-1", secret, pullRequestEvent("synchronize"))
+	response, err := e.entry.Handle(context.Background(), request)
+	if err != nil || response.StatusCode != 500 {
+		t.Fatalf("Expected the webhook to fail with 500, got %d, error: %v", response.StatusCode, err)
+	}
+	e.webhook("pull_request", "delivery-1", pullRequestEvent("synchronize"))
+
+	if len(e.executions.Started()) != 1 {
+		t.Fatalf("Expected the retried webhook to start 1 execution, it started %d", len(e.executions.Started()))
+	}
+	e.expectComments()
+}
+
+func TestLogsCommand(t *testing.T) {
+	e := newEnv(t)
+	p := failingPipeline()
+	p.HiddenFor = 0
+	p.Workflows[0].Jobs[1].Statuses = []string{"failed"}
+	e.circle.AddPipeline(p)
+	e.github.SetPermission(owner, repo, "mona", "write")
+	e.github.AddPullRequest(fakegithub.PullRequest{Owner: owner, Repo: repo, Number: 7, Author: "mona", HeadRef: "feature", HeadSHA: sha, BaseRef: "main"})
+
+	e.webhook("issue_comment", "delivery-2", commentEvent("mona", "/circleci logs test"))
+
+	e.expectComments("@mona Output of `test`\n" +
+		"```\n" +
+		"--- FAIL: TestAdd\n" +
+		"expected 3, got 4\n" +
+		"FAIL\n" +
+		"```")
+}
+
+func TestReadOnlyCommenter(t *testing.T) {
+	e := newEnv(t)
+
+	e.webhook("issue_comment", "delivery-3", commentEvent("stranger", "/circleci cancel"))
+
+	e.expectComments("@stranger you need write access to this repository to run `/circleci` commands")
+	for _, r := range e.circle.Requests() {
+		t.Errorf("Expected no CircleCI requests, got %s", r)
+	}
+}
+
+func TestCircleCIServer(t *testing.T) {
+	e := newEnv(t)
+	// only the host of the server install answers, nothing is sent to circleci.com
+	harness.Install(t, map[string]string{
+		"circleci.example.com": e.circle.URL,
+		fakegithub.Host:        e.github.URL,
+	})
+	e.config.CircleHost = "circleci.example.com"
+	e.entry.Config = e.config
+
+	p := failingPipeline()
+	p.HiddenFor = 0
+	p.Workflows[0].Jobs[1].Statuses = []string{"failed"}
+	e.circle.AddPipeline(p)
+	e.github.SetPermission(owner, repo, "mona", "write")
+	e.github.AddPullRequest(fakegithub.PullRequest{Owner: owner, Repo: repo, Number: 7, Author: "mona", HeadRef: "feature", HeadSHA: sha, BaseRef: "main"})
+
+	e.webhook("issue_comment", "delivery-4", commentEvent("mona", "/circleci logs test"))
+
+	e.expectComments("@mona Output of `test`\n" +
+		"```\n" +
+		"--- FAIL: TestAdd\n" +
+		"expected 3, got 4\n" +
+		"FAIL\n" +
+		"```")
+	// the step logs come from the v1.1 API of the server install too
+	found := false
+	for _, r := range e.circle.Requests() {
+		found = found || strings.HasPrefix(r, "GET /api/v1.1/project/gh/"+owner+"/"+repo+"/")
+	}
+	if !found {
+		t.Errorf("Expected the step logs to be read from the v1.1 API of the server install, got %v", e.circle.Requests())
+	}
+}
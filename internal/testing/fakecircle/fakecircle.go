@@ -0,0 +1,457 @@
+// Package fakecircle is a fake CircleCI API for tests, it serves the v2 and v1.1 endpoints the functions use
+// from pipelines a test scripts, with jobs that go through the statuses the test gives them as they are polled
+package fakecircle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codingdiaz/circleci-feedback/internal/testing/harness"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+// Host is the host the functions call CircleCI on, redirect it to the server with harness.Install
+const Host = "circleci.com"
+
+// Server is a fake CircleCI
+type Server struct {
+	*httptest.Server
+
+	// Replay has recorded responses, they are served before the scripted pipelines
+	Replay harness.Replayer
+
+	mu        sync.Mutex
+	pipelines []*Pipeline
+	requests  []string
+	reruns    int
+}
+
+// Pipeline is a scripted pipeline
+type Pipeline struct {
+	ID       string
+	Slug     string // project slug, like gh/octo/app
+	Number   int
+	Branch   string
+	Revision string
+	// HiddenFor is how many listings of the pipelines of the project leave the pipeline out,
+	// like CircleCI taking a moment to create it
+	HiddenFor int
+	Workflows []*Workflow
+
+	created time.Time
+}
+
+// Workflow is a workflow of a scripted pipeline
+type Workflow struct {
+	ID   string
+	Name string
+	Jobs []*Job
+}
+
+// Job is a job of a scripted workflow
+type Job struct {
+	ID     string
+	Name   string
+	Number int
+	Type   string // build or approval, defaults to build
+	// Statuses are what the job goes through, every listing of the jobs of its workflow moves it to the next one
+	// and it stays at the last one, like running then failed
+	Statuses []string
+	// Steps are the steps of the build, the output of their actions is served at their output_url
+	Steps       []Step
+	Parallelism int
+	Tests       []circleci.TestResult
+
+	polls   int
+	started time.Time
+	stopped time.Time
+}
+
+// Step is a step of a job, with an action per container
+type Step struct {
+	Name    string
+	Actions []Action
+}
+
+// Action is a step on one container
+type Action struct {
+	Index  int
+	Status string // success or failed
+	Output string
+}
+
+// NewServer starts a fake CircleCI, it is closed when the test ends
+func NewServer(t testing.TB) *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// AddPipeline adds a pipeline, it is listed before the pipelines added before it like newer pipelines are
+func (s *Server) AddPipeline(p *Pipeline) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.created = time.Now()
+	s.pipelines = append([]*Pipeline{p}, s.pipelines...)
+}
+
+// Requests returns the method and path of every request the server got, like GET /api/v2/pipeline/1
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+
+	if s.Replay.Serve(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 6 && match(parts, "api", "v2", "project", "*", "*", "*") && r.Method == "GET":
+		s.project(w, parts[3]+"/"+parts[4]+"/"+parts[5])
+	case len(parts) == 7 && match(parts, "api", "v2", "project", "*", "*", "*", "pipeline") && r.Method == "GET":
+		s.listPipelines(w, parts[3]+"/"+parts[4]+"/"+parts[5], r.URL.Query().Get("branch"))
+	case len(parts) == 8 && match(parts, "api", "v2", "project", "*", "*", "*", "*", "tests") && r.Method == "GET":
+		s.tests(w, parts[6])
+	case len(parts) == 4 && match(parts, "api", "v2", "pipeline", "*") && r.Method == "GET":
+		s.pipeline(w, parts[3])
+	case len(parts) == 4 && match(parts, "api", "v2", "workflow", "*") && r.Method == "GET":
+		s.workflow(w, parts[3])
+	case len(parts) == 5 && match(parts, "api", "v2", "workflow", "*", "jobs") && r.Method == "GET":
+		s.jobs(w, parts[3])
+	case len(parts) == 5 && match(parts, "api", "v2", "workflow", "*", "rerun") && r.Method == "POST":
+		s.rerun(w, r, parts[3])
+	case len(parts) == 5 && match(parts, "api", "v2", "workflow", "*", "cancel") && r.Method == "POST":
+		s.cancel(w, parts[3])
+	case len(parts) == 7 && match(parts, "api", "v1.1", "project", "*", "*", "*", "*") && r.Method == "GET":
+		s.build(w, parts[6])
+	case len(parts) == 4 && match(parts, "output", "*", "*", "*") && r.Method == "GET":
+		s.output(w, parts[1], parts[2], parts[3])
+	default:
+		reply(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+	}
+}
+
+// match says whether parts are want, * matches any part
+func match(parts []string, want ...string) bool {
+	for i, w := range want {
+		if w != "*" && parts[i] != w {
+			return false
+		}
+	}
+	return true
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter, what string) {
+	reply(w, http.StatusNotFound, map[string]string{"message": what + " not found"})
+}
+
+func (s *Server) project(w http.ResponseWriter, slug string) {
+	parts := strings.Split(slug, "/")
+	reply(w, http.StatusOK, circleci.Project{Slug: slug, Name: parts[2], OrganizationName: parts[1]})
+}
+
+func (s *Server) listPipelines(w http.ResponseWriter, slug, branch string) {
+	items := []circleci.Pipeline{}
+	for _, p := range s.pipelines {
+		if p.Slug != slug {
+			continue
+		}
+		if p.HiddenFor > 0 {
+			p.HiddenFor--
+			continue
+		}
+		if branch != "" && p.Branch != branch {
+			continue
+		}
+		items = append(items, p.api())
+	}
+
+	reply(w, http.StatusOK, circleci.GetProjectPipelinesResponse{Items: items})
+}
+
+func (s *Server) pipeline(w http.ResponseWriter, id string) {
+	for _, p := range s.pipelines {
+		if p.ID == id {
+			reply(w, http.StatusOK, p.api())
+			return
+		}
+	}
+	notFound(w, "Pipeline")
+}
+
+func (s *Server) workflow(w http.ResponseWriter, id string) {
+	p, wf := s.findWorkflow(id)
+	if wf == nil {
+		notFound(w, "Workflow")
+		return
+	}
+
+	reply(w, http.StatusOK, circleci.Workflow{
+		ID:             wf.ID,
+		Name:           wf.Name,
+		PipelineID:     p.ID,
+		PipelineNumber: p.Number,
+		ProjectSlug:    p.Slug,
+		Status:         wf.status(),
+		CreatedAt:      p.created,
+	})
+}
+
+// jobs lists the jobs of a workflow, moving every job to its next status
+func (s *Server) jobs(w http.ResponseWriter, id string) {
+	p, wf := s.findWorkflow(id)
+	if wf == nil {
+		notFound(w, "Workflow")
+		return
+	}
+
+	items := []circleci.Job{}
+	for _, job := range wf.Jobs {
+		job.poll()
+		items = append(items, job.api(p.Slug))
+	}
+
+	reply(w, http.StatusOK, circleci.GetWorkflowJobsResponse{Items: items})
+}
+
+// rerun adds a workflow with the jobs of the workflow to the pipeline, every job runs and succeeds
+func (s *Server) rerun(w http.ResponseWriter, r *http.Request, id string) {
+	p, wf := s.findWorkflow(id)
+	if wf == nil {
+		notFound(w, "Workflow")
+		return
+	}
+
+	opts := circleci.RerunWorkflowOptions{}
+	json.NewDecoder(r.Body).Decode(&opts)
+
+	s.reruns++
+	rerun := &Workflow{ID: fmt.Sprintf("%s-rerun-%d", wf.ID, s.reruns), Name: wf.Name}
+	for _, job := range wf.Jobs {
+		if opts.FromFailed && job.status() != "failed" {
+			continue
+		}
+		rerun.Jobs = append(rerun.Jobs, &Job{
+			ID:       job.ID + "-rerun",
+			Name:     job.Name,
+			Number:   job.Number + 1000,
+			Type:     job.Type,
+			Statuses: []string{"running", "success"},
+		})
+	}
+	p.Workflows = append(p.Workflows, rerun)
+
+	reply(w, http.StatusAccepted, circleci.RerunWorkflowResponse{WorkflowID: rerun.ID})
+}
+
+func (s *Server) cancel(w http.ResponseWriter, id string) {
+	_, wf := s.findWorkflow(id)
+	if wf == nil {
+		notFound(w, "Workflow")
+		return
+	}
+
+	for _, job := range wf.Jobs {
+		if status := job.status(); status != "success" && status != "failed" {
+			job.Statuses = []string{"canceled"}
+			job.polls = 0
+		}
+	}
+	reply(w, http.StatusAccepted, map[string]string{"message": "Accepted."})
+}
+
+func (s *Server) tests(w http.ResponseWriter, number string) {
+	job := s.findJob(number)
+	if job == nil {
+		notFound(w, "Job")
+		return
+	}
+
+	reply(w, http.StatusOK, circleci.GetJobTestsResponse{Items: job.Tests})
+}
+
+// build serves a job as a v1.1 build, its actions point at the output endpoint of the server
+func (s *Server) build(w http.ResponseWriter, number string) {
+	job := s.findJob(number)
+	if job == nil {
+		notFound(w, "Build")
+		return
+	}
+
+	build := circleci.Build{BuildNum: job.Number, Parallel: job.Parallelism, Status: job.status(), Steps: s.steps(job)}
+	reply(w, http.StatusOK, build)
+}
+
+func (s *Server) steps(job *Job) []*circleci.Step {
+	steps := []*circleci.Step{}
+	for i, step := range job.Steps {
+		apiStep := &circleci.Step{Name: step.Name}
+		for _, a := range step.Actions {
+			apiStep.Actions = append(apiStep.Actions, &circleci.Action{
+				Name:      step.Name,
+				Index:     a.Index,
+				Step:      i,
+				Status:    a.Status,
+				HasOutput: a.Output != "",
+				OutputURL: fmt.Sprintf("%s/output/%d/%d/%d", s.URL, job.Number, i, a.Index),
+			})
+		}
+		steps = append(steps, apiStep)
+	}
+
+	return steps
+}
+
+func (s *Server) output(w http.ResponseWriter, number, step, index string) {
+	job := s.findJob(number)
+	i, _ := strconv.Atoi(step)
+	if job == nil || i >= len(job.Steps) {
+		notFound(w, "Output")
+		return
+	}
+
+	for _, a := range job.Steps[i].Actions {
+		if strconv.Itoa(a.Index) == index {
+			reply(w, http.StatusOK, []circleci.BuildOutput{{Message: a.Output, Type: "out"}})
+			return
+		}
+	}
+	notFound(w, "Output")
+}
+
+func (s *Server) findWorkflow(id string) (*Pipeline, *Workflow) {
+	for _, p := range s.pipelines {
+		for _, wf := range p.Workflows {
+			if wf.ID == id {
+				return p, wf
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) findJob(number string) *Job {
+	for _, p := range s.pipelines {
+		for _, wf := range p.Workflows {
+			for _, job := range wf.Jobs {
+				if strconv.Itoa(job.Number) == number {
+					return job
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) api() circleci.Pipeline {
+	pipeline := circleci.Pipeline{
+		ID:          p.ID,
+		ProjectSlug: p.Slug,
+		Number:      p.Number,
+		State:       "created",
+		CreatedAt:   p.created,
+		UpdatedAt:   p.created,
+	}
+	pipeline.Vcs.Branch = p.Branch
+	pipeline.Vcs.Revision = p.Revision
+	for _, wf := range p.Workflows {
+		pipeline.Workflows = append(pipeline.Workflows, struct {
+			ID string `json:"id"`
+		}{ID: wf.ID})
+	}
+
+	return pipeline
+}
+
+// status is failed once a job failed, running while any job isn't done and success after that
+func (wf *Workflow) status() string {
+	failed, running := false, false
+	for _, job := range wf.Jobs {
+		switch job.status() {
+		case "failed":
+			failed = true
+		case "success", "canceled":
+		default:
+			running = true
+		}
+	}
+
+	switch {
+	case failed && running:
+		return "failing"
+	case failed:
+		return "failed"
+	case running:
+		return "running"
+	default:
+		return "success"
+	}
+}
+
+func (j *Job) status() string {
+	if len(j.Statuses) == 0 {
+		return "success"
+	}
+	if j.polls == 0 {
+		return j.Statuses[0]
+	}
+	if j.polls > len(j.Statuses) {
+		return j.Statuses[len(j.Statuses)-1]
+	}
+	return j.Statuses[j.polls-1]
+}
+
+// poll moves j to its next status, a job is started when it is first seen and stopped once it succeeded or failed
+func (j *Job) poll() {
+	j.polls++
+	if j.started.IsZero() {
+		j.started = time.Now()
+	}
+	if status := j.status(); j.stopped.IsZero() && (status == "success" || status == "failed" || status == "canceled") {
+		j.stopped = time.Now()
+	}
+}
+
+func (j *Job) api(slug string) circleci.Job {
+	job := circleci.Job{
+		ID:          j.ID,
+		Name:        j.Name,
+		JobNumber:   j.Number,
+		ProjectSlug: slug,
+		Status:      j.status(),
+		Type:        j.Type,
+		StartTime:   j.started,
+		StopTime:    j.stopped,
+	}
+	if job.Type == "" {
+		job.Type = "build"
+	}
+
+	return job
+}
@@ -0,0 +1,307 @@
+// Package fakegithub is a fake GitHub API for tests, it serves the endpoints the functions use and keeps
+// the comments they post so tests can check them, it also signs the webhooks tests send the entry function
+package fakegithub
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/harness"
+)
+
+// Host is the host the functions call GitHub on, redirect it to the server with harness.Install
+const Host = "api.github.com"
+
+// BotLogin is the login comments posted through the server are made by
+const BotLogin = "circleci-feedback[bot]"
+
+// Server is a fake GitHub
+type Server struct {
+	*httptest.Server
+
+	// Replay has recorded responses, they are served before the scripted repos
+	Replay harness.Replayer
+
+	mu          sync.Mutex
+	files       map[string]string // owner/repo/path to content
+	permissions map[string]string // owner/repo/login to permission level
+	pulls       map[string]PullRequest
+	comments    []*Comment
+	nextID      int64
+	requests    []string
+}
+
+// Comment is a comment posted on an issue or pull request
+type Comment struct {
+	ID     int64
+	Owner  string
+	Repo   string
+	Number int
+	Body   string
+	Edits  int // times the comment was edited
+}
+
+// PullRequest is a scripted pull request
+type PullRequest struct {
+	Owner   string
+	Repo    string
+	Number  int
+	Author  string
+	HeadRef string
+	HeadSHA string
+	BaseRef string
+}
+
+// NewServer starts a fake GitHub, it is closed when the test ends
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		files:       map[string]string{},
+		permissions: map[string]string{},
+		pulls:       map[string]PullRequest{},
+		nextID:      1000,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// AddFile adds a file to the default branch of a repo, every ref has the same files
+func (s *Server) AddFile(owner, repo, path, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[owner+"/"+repo+"/"+path] = content
+}
+
+// SetPermission gives login a permission level on a repo, like admin, write or read
+func (s *Server) SetPermission(owner, repo, login, level string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permissions[owner+"/"+repo+"/"+login] = level
+}
+
+// AddPullRequest adds a pull request
+func (s *Server) AddPullRequest(pr PullRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pulls[fmt.Sprintf("%s/%s/%d", pr.Owner, pr.Repo, pr.Number)] = pr
+}
+
+// Comments returns the comments on a pull request, oldest first
+func (s *Server) Comments(owner, repo string, number int) []Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comments := []Comment{}
+	for _, c := range s.comments {
+		if c.Owner == owner && c.Repo == repo && c.Number == number {
+			comments = append(comments, *c)
+		}
+	}
+	return comments
+}
+
+// Requests returns the method and path of every request the server got
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+
+	if s.Replay.Serve(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// GitHub Enterprise Server has the same API under /api/v3
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v3"), "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[len(parts)-1] == "access_tokens" && r.Method == "POST":
+		reply(w, http.StatusCreated, map[string]interface{}{"token": "ghs_fake", "expires_at": time.Now().Add(time.Hour)})
+	case len(parts) >= 4 && parts[0] == "repos" && parts[3] == "contents" && r.Method == "GET":
+		s.contents(w, parts[1], parts[2], strings.Join(parts[4:], "/"))
+	case len(parts) == 6 && parts[0] == "repos" && parts[3] == "collaborators" && parts[5] == "permission" && r.Method == "GET":
+		s.permission(w, parts[1], parts[2], parts[4])
+	case len(parts) == 5 && parts[0] == "repos" && parts[3] == "pulls" && r.Method == "GET":
+		s.pull(w, parts[1], parts[2], parts[4])
+	case len(parts) == 6 && parts[0] == "repos" && parts[3] == "issues" && parts[5] == "comments" && r.Method == "POST":
+		s.createComment(w, r, parts[1], parts[2], parts[4])
+	case len(parts) == 6 && parts[0] == "repos" && parts[3] == "issues" && parts[4] == "comments":
+		s.comment(w, r, parts[5])
+	default:
+		reply(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter) {
+	reply(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+func (s *Server) contents(w http.ResponseWriter, owner, repo, path string) {
+	content, ok := s.files[owner+"/"+repo+"/"+path]
+	if !ok {
+		notFound(w)
+		return
+	}
+
+	reply(w, http.StatusOK, map[string]interface{}{
+		"type":     "file",
+		"encoding": "base64",
+		"path":     path,
+		"size":     len(content),
+		"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+	})
+}
+
+func (s *Server) permission(w http.ResponseWriter, owner, repo, login string) {
+	level, ok := s.permissions[owner+"/"+repo+"/"+login]
+	if !ok {
+		level = "read"
+	}
+
+	reply(w, http.StatusOK, map[string]interface{}{"permission": level, "user": map[string]string{"login": login}})
+}
+
+func (s *Server) pull(w http.ResponseWriter, owner, repo, number string) {
+	pr, ok := s.pulls[owner+"/"+repo+"/"+number]
+	if !ok {
+		notFound(w)
+		return
+	}
+
+	reply(w, http.StatusOK, map[string]interface{}{
+		"number": pr.Number,
+		"user":   map[string]string{"login": pr.Author},
+		"head":   map[string]string{"ref": pr.HeadRef, "sha": pr.HeadSHA},
+		"base":   map[string]string{"ref": pr.BaseRef},
+	})
+}
+
+func (s *Server) createComment(w http.ResponseWriter, r *http.Request, owner, repo, number string) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		notFound(w)
+		return
+	}
+
+	body := struct {
+		Body string `json:"body"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		reply(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+
+	s.nextID++
+	c := &Comment{ID: s.nextID, Owner: owner, Repo: repo, Number: n, Body: body.Body}
+	s.comments = append(s.comments, c)
+
+	reply(w, http.StatusCreated, c.api())
+}
+
+func (s *Server) comment(w http.ResponseWriter, r *http.Request, id string) {
+	var c *Comment
+	for _, comment := range s.comments {
+		if strconv.FormatInt(comment.ID, 10) == id {
+			c = comment
+		}
+	}
+	if c == nil {
+		notFound(w)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		reply(w, http.StatusOK, c.api())
+	case "PATCH":
+		body := struct {
+			Body string `json:"body"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			reply(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+			return
+		}
+		c.Body = body.Body
+		c.Edits++
+		reply(w, http.StatusOK, c.api())
+	default:
+		notFound(w)
+	}
+}
+
+func (c *Comment) api() map[string]interface{} {
+	return map[string]interface{}{
+		"id":   c.ID,
+		"body": c.Body,
+		"user": map[string]string{"login": BotLogin, "type": "Bot"},
+	}
+}
+
+// PrivateKey returns a new PEM encoded private key for a GitHub App, the server accepts any key
+func PrivateKey(t testing.TB) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating a private key, error: %s", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// Webhook returns the request API Gateway passes the entry function for a webhook delivery of event,
+// signed with secret like GitHub signs it
+func Webhook(t testing.TB, event, delivery, secret string, payload interface{}) events.APIGatewayProxyRequest {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Error encoding the webhook payload, error: %s", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/entry",
+		Headers: map[string]string{
+			"X-GitHub-Event":      event,
+			"X-GitHub-Delivery":   delivery,
+			"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+			"Content-Type":        "application/json",
+		},
+		Body: string(body),
+	}
+}
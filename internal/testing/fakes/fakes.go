@@ -0,0 +1,370 @@
+// Package fakes has in memory fakes of the services the handlers are wired to, for unit tests that don't
+// need the HTTP APIs the fake servers of fakecircle and fakegithub serve
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+	"github.com/google/go-github/github"
+)
+
+// CircleCI is a fake CircleCI API, tests fill in what it returns, methods nothing was filled in for fail
+type CircleCI struct {
+	Pipelines []circleci.Pipeline           // every pipeline of every project, newest first
+	Workflows map[string]*circleci.Workflow // by ID
+	Jobs      map[string][]circleci.Job     // by workflow ID
+	Builds    map[int]*circleci.Build       // v1.1 builds by job number, their steps are the step logs of the job
+	Outputs   map[string]string             // step output by the output URL of the action
+	Tests     map[int][]circleci.TestResult // by job number
+	Err       error                         // returned by every method when set
+
+	mu       sync.Mutex
+	Reruns   []string // the workflows that were rerun
+	Canceled []string // the workflows that were canceled
+}
+
+var _ circleci.API = (*CircleCI)(nil)
+var _ stepfunc.CircleCIClients = (*CircleCI)(nil)
+
+// Client returns f
+func (f *CircleCI) Client(ctx context.Context, c stepfunc.Config, logger *slog.Logger) circleci.API {
+	return f
+}
+
+// WithContext returns f
+func (f *CircleCI) WithContext(ctx context.Context) circleci.API {
+	return f
+}
+
+// Pipeline returns a pipeline of the project slug that built sha on branch, with the workflows workflowIDs
+func Pipeline(id, slug, branch, sha string, workflowIDs ...string) circleci.Pipeline {
+	p := circleci.Pipeline{ID: id, ProjectSlug: slug, State: "created"}
+	p.Vcs.Branch = branch
+	p.Vcs.Revision = sha
+	for _, w := range workflowIDs {
+		p.Workflows = append(p.Workflows, struct {
+			ID string `json:"id"`
+		}{ID: w})
+	}
+
+	return p
+}
+
+func notFaked(what string) error {
+	return &circleci.APIError{HTTPStatusCode: 404, Message: what + " not found"}
+}
+
+// GetBuild returns the build of Builds
+func (f *CircleCI) GetBuild(vcs, account, repo string, buildNum int) (*circleci.Build, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if build, ok := f.Builds[buildNum]; ok {
+		return build, nil
+	}
+	return nil, notFaked(fmt.Sprintf("build %d", buildNum))
+}
+
+// StepLogs returns the steps of the build of Builds
+func (f *CircleCI) StepLogs(job circleci.JobRef) (*circleci.JobLogs, error) {
+	build, err := f.GetBuild(job.VCS, job.Account, job.Repo, job.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := &circleci.JobLogs{Parallelism: build.Parallel}
+	for _, step := range build.Steps {
+		for _, action := range step.Actions {
+			logs.Steps = append(logs.Steps, circleci.StepLog{Step: step.Name, Container: action.Index, Status: action.Status, OutputURL: action.OutputURL})
+		}
+	}
+	return logs, nil
+}
+
+// StepOutput returns the output of Outputs
+func (f *CircleCI) StepOutput(log circleci.StepLog) (string, error) {
+	if f.Err != nil {
+		return "", f.Err
+	}
+	return f.Outputs[log.OutputURL], nil
+}
+
+// Me fails
+func (f *CircleCI) Me() (*circleci.User, error) {
+	return nil, notFaked("user")
+}
+
+// GetProject returns a project named after the repo
+func (f *CircleCI) GetProject(vcsProvider, account, repo string) (*circleci.Project, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return &circleci.Project{Slug: circleci.ProjectSlug(vcsProvider, account, repo), Name: repo, OrganizationName: account}, nil
+}
+
+// GetWorkflow returns the workflow of Workflows
+func (f *CircleCI) GetWorkflow(workflowID string) (*circleci.Workflow, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if workflow, ok := f.Workflows[workflowID]; ok {
+		return workflow, nil
+	}
+	return nil, notFaked("workflow " + workflowID)
+}
+
+// GetWorkflowJobs returns the jobs of Jobs
+func (f *CircleCI) GetWorkflowJobs(workflowID string) ([]circleci.Job, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if jobs, ok := f.Jobs[workflowID]; ok {
+		return jobs, nil
+	}
+	return nil, notFaked("workflow " + workflowID)
+}
+
+// GetProjectPipelines returns the pipelines of the project
+func (f *CircleCI) GetProjectPipelines(vcsProvider, account, repo string) ([]circleci.Pipeline, error) {
+	return f.FindPipelines(vcsProvider, account, repo, circleci.FindPipelinesOptions{})
+}
+
+// FindPipelines returns the pipelines of the project matching opts
+func (f *CircleCI) FindPipelines(vcsProvider, account, repo string, opts circleci.FindPipelinesOptions) ([]circleci.Pipeline, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	found := []circleci.Pipeline{}
+	for _, p := range f.Pipelines {
+		if p.ProjectSlug != circleci.ProjectSlug(vcsProvider, account, repo) {
+			continue
+		}
+		if (opts.Branch != "" && p.Vcs.Branch != opts.Branch) || (opts.Revision != "" && p.Vcs.Revision != opts.Revision) {
+			continue
+		}
+		found = append(found, p)
+	}
+	return found, nil
+}
+
+// GetJobTests returns the tests of Tests
+func (f *CircleCI) GetJobTests(vcsProvider, account, repo string, jobNumber int) ([]circleci.TestResult, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Tests[jobNumber], nil
+}
+
+// GetPipeline returns the pipeline of Pipelines
+func (f *CircleCI) GetPipeline(pipelineID string) (*circleci.Pipeline, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	for i := range f.Pipelines {
+		if f.Pipelines[i].ID == pipelineID {
+			return &f.Pipelines[i], nil
+		}
+	}
+	return nil, notFaked("pipeline " + pipelineID)
+}
+
+// RerunWorkflow records the rerun, the new workflow is named after the one that was rerun
+func (f *CircleCI) RerunWorkflow(workflowID string, opts circleci.RerunWorkflowOptions) (*circleci.RerunWorkflowResponse, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Reruns = append(f.Reruns, workflowID)
+	return &circleci.RerunWorkflowResponse{WorkflowID: fmt.Sprintf("%s-rerun-%d", workflowID, len(f.Reruns))}, nil
+}
+
+// CancelWorkflow records the cancel
+func (f *CircleCI) CancelWorkflow(workflowID string) error {
+	if f.Err != nil {
+		return f.Err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Canceled = append(f.Canceled, workflowID)
+	return nil
+}
+
+// CancelJob fails
+func (f *CircleCI) CancelJob(vcsProvider, account, repo string, jobNumber int) error {
+	return notFaked(fmt.Sprintf("job %d", jobNumber))
+}
+
+// ApproveJob fails
+func (f *CircleCI) ApproveJob(workflowID, approvalRequestID string) error {
+	return notFaked("approval " + approvalRequestID)
+}
+
+// TriggerPipeline fails
+func (f *CircleCI) TriggerPipeline(vcsProvider, account, repo string, opts circleci.TriggerPipelineOptions) (*circleci.PipelineCreated, error) {
+	return nil, notFaked("project " + circleci.ProjectSlug(vcsProvider, account, repo))
+}
+
+// GitHub is a fake GitHub API, it is handed out for every installation
+type GitHub struct {
+	Files        map[string]string              // content by owner/repo/path, every ref has the same files
+	Permissions  map[string]string              // permission level by owner/repo/login, read when there is none
+	PullRequests map[string]*github.PullRequest // by owner/repo/number
+	Err          error                          // returned by every method when set
+
+	mu       sync.Mutex
+	comments []Comment
+}
+
+// Comment is a comment posted on the fake GitHub
+type Comment struct {
+	ID     int64
+	Owner  string
+	Repo   string
+	Number int
+	Body   string
+}
+
+var _ githubapp.API = (*GitHub)(nil)
+var _ githubapp.Installations = (*GitHub)(nil)
+
+// Installation returns f
+func (f *GitHub) Installation(installationID int64) (githubapp.API, error) {
+	return f, nil
+}
+
+// Comments returns the comments on every issue and pull request, oldest first
+func (f *GitHub) Comments() []Comment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Comment{}, f.comments...)
+}
+
+// CreateComment adds a comment
+func (f *GitHub) CreateComment(ctx context.Context, owner, repo string, number int, body string) (*github.IssueComment, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := Comment{ID: int64(len(f.comments) + 1), Owner: owner, Repo: repo, Number: number, Body: body}
+	f.comments = append(f.comments, c)
+	return &github.IssueComment{ID: &c.ID, Body: &c.Body}, nil
+}
+
+// GetComment returns a comment, ErrNotFound when there is none with id
+func (f *GitHub) GetComment(ctx context.Context, owner, repo string, id int64) (*github.IssueComment, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.comments {
+		if c.ID == id {
+			return &github.IssueComment{ID: &c.ID, Body: &c.Body}, nil
+		}
+	}
+	return nil, githubapp.ErrNotFound
+}
+
+// EditComment replaces the body of a comment, ErrNotFound when there is none with id
+func (f *GitHub) EditComment(ctx context.Context, owner, repo string, id int64, body string) (*github.IssueComment, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.comments {
+		if f.comments[i].ID == id {
+			f.comments[i].Body = body
+			return &github.IssueComment{ID: &id, Body: &body}, nil
+		}
+	}
+	return nil, githubapp.ErrNotFound
+}
+
+// GetFile returns a file of Files, ErrNotFound when there is none at path
+func (f *GitHub) GetFile(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if content, ok := f.Files[owner+"/"+repo+"/"+path]; ok {
+		return []byte(content), nil
+	}
+	return nil, githubapp.ErrNotFound
+}
+
+// GetPermissionLevel returns the permission level of Permissions, read when there is none
+func (f *GitHub) GetPermissionLevel(ctx context.Context, owner, repo, login string) (string, error) {
+	if f.Err != nil {
+		return "", f.Err
+	}
+	if level, ok := f.Permissions[owner+"/"+repo+"/"+login]; ok {
+		return level, nil
+	}
+	return "read", nil
+}
+
+// GetPullRequest returns a pull request of PullRequests, ErrNotFound when there is none
+func (f *GitHub) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if pr, ok := f.PullRequests[fmt.Sprintf("%s/%s/%d", owner, repo, number)]; ok {
+		return pr, nil
+	}
+	return nil, githubapp.ErrNotFound
+}
+
+// Executions records the step function executions it is asked to start
+type Executions struct {
+	Err error // returned instead of starting an execution when set
+
+	mu      sync.Mutex
+	started []Execution
+}
+
+// Execution is an execution that was started
+type Execution struct {
+	Name  string
+	Input stepfunc.Data
+}
+
+// StartExecution records the execution, or returns Err
+func (f *Executions) StartExecution(input stepfunc.Data, name string) error {
+	if f.Err != nil {
+		return f.Err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.started = append(f.started, Execution{Name: name, Input: input})
+	return nil
+}
+
+// Started returns the executions that were started, in the order they were
+func (f *Executions) Started() []Execution {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Execution{}, f.started...)
+}
@@ -0,0 +1,176 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// secretParams are left out of recorded queries, the CircleCI token is sent in one
+var secretParams = []string{"circle-token"}
+
+// Fixture is a recorded API response
+type Fixture struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Query  string          `json:"query,omitempty"` // only requests with this query match when it is set
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// matches says whether r is a request for f
+func (f Fixture) matches(r *http.Request) bool {
+	if f.Method != r.Method || f.Path != r.URL.Path {
+		return false
+	}
+	if f.Query == "" {
+		return true
+	}
+
+	want, err := url.ParseQuery(f.Query)
+	if err != nil {
+		return false
+	}
+	return want.Encode() == query(r.URL)
+}
+
+// query is the query of u without secretParams, encoded in a stable order
+func query(u *url.URL) string {
+	q := u.Query()
+	for _, p := range secretParams {
+		q.Del(p)
+	}
+	return q.Encode()
+}
+
+// LoadFixtures reads the fixtures in the JSON file at path
+func LoadFixtures(path string) ([]Fixture, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading fixtures, error: %s", err)
+	}
+
+	fixtures := []Fixture{}
+	err = json.Unmarshal(b, &fixtures)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing fixtures in %s, error: %s", path, err)
+	}
+
+	return fixtures, nil
+}
+
+// SaveFixtures writes fixtures to the JSON file at path
+func SaveFixtures(path string, fixtures []Fixture) error {
+	b, err := json.MarshalIndent(fixtures, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error encoding fixtures, error: %s", err)
+	}
+
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Replayer serves fixtures, a request that matches more than one fixture gets them in the order they were
+// added and the last one from then on, so a recording of polls replays the changes it saw
+type Replayer struct {
+	mu       sync.Mutex
+	fixtures []Fixture
+	served   map[int]bool
+}
+
+// Add adds fixtures to the ones r serves
+func (r *Replayer) Add(fixtures ...Fixture) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fixtures = append(r.fixtures, fixtures...)
+}
+
+// Serve answers req with the next fixture matching it, it returns false when there is none
+func (r *Replayer) Serve(w http.ResponseWriter, req *http.Request) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.served == nil {
+		r.served = map[int]bool{}
+	}
+
+	last := -1
+	for i, f := range r.fixtures {
+		if !f.matches(req) {
+			continue
+		}
+		last = i
+		if !r.served[i] {
+			break
+		}
+	}
+	if last == -1 {
+		return false
+	}
+
+	r.served[last] = true
+	f := r.fixtures[last]
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(f.Status)
+	w.Write(f.Body)
+	return true
+}
+
+// Recorder is a transport that keeps the responses to the requests sent through it as fixtures,
+// for making fixtures from the real APIs
+type Recorder struct {
+	Base http.RoundTripper // defaults to http.DefaultTransport
+
+	mu       sync.Mutex
+	fixtures []Fixture
+}
+
+// RoundTrip sends req with Base and records the response, responses that aren't JSON are recorded as a JSON string
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := r.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	recorded := json.RawMessage(body)
+	if len(body) > 0 && !json.Valid(body) {
+		recorded, _ = json.Marshal(string(body))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixtures = append(r.fixtures, Fixture{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  query(req.URL),
+		Status: resp.StatusCode,
+		Body:   recorded,
+	})
+
+	return resp, nil
+}
+
+// Fixtures returns what r recorded so far
+func (r *Recorder) Fixtures() []Fixture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Fixture{}, r.fixtures...)
+}
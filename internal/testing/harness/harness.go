@@ -0,0 +1,59 @@
+// Package harness points the HTTP clients of the functions at fake servers, and records and replays
+// API responses as fixtures so tests can run against what the real APIs returned
+package harness
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Redirect is a transport that sends the requests for a host to a test server instead
+// Requests for hosts it doesn't know fail, tests never reach the real APIs
+type Redirect struct {
+	Hosts map[string]string // host, like circleci.com, to the URL of the server that answers for it
+	Base  http.RoundTripper // sends the redirected requests, defaults to a new http.Transport
+}
+
+// RoundTrip sends req to the server of its host, requests made straight to a test server go through as they are
+func (r *Redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := r.Base
+	if base == nil {
+		base = defaultTransport
+	}
+
+	for host, target := range r.Hosts {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing the URL of the server for %s, error: %s", host, err)
+		}
+
+		if req.URL.Host == u.Host {
+			return base.RoundTrip(req)
+		}
+		if req.URL.Host == host {
+			req = req.Clone(req.Context())
+			req.URL.Scheme = u.Scheme
+			req.URL.Host = u.Host
+			req.Host = u.Host
+			return base.RoundTrip(req)
+		}
+	}
+
+	return nil, fmt.Errorf("no test server for %s", req.URL.Host)
+}
+
+// defaultTransport is the transport before Install replaced it
+var defaultTransport = http.DefaultTransport
+
+// Install sends every request made with http.DefaultTransport, which every client of the functions ends up
+// using, to the servers in hosts until the test ends
+// Tests that install a redirect can't run in parallel with each other
+func Install(t testing.TB, hosts map[string]string) {
+	previous := http.DefaultTransport
+	http.DefaultTransport = &Redirect{Hosts: hosts}
+	t.Cleanup(func() {
+		http.DefaultTransport = previous
+	})
+}
@@ -0,0 +1,238 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBuffered caps the spans kept between two flushes, spans ended after that are dropped
+const maxBuffered = 2048
+
+// exporter sends the ended spans, it is nil and spans are only used for their IDs until Setup finds an endpoint
+var exporter *Exporter
+
+// Exporter sends spans to an OpenTelemetry collector with OTLP over HTTP, encoded as JSON
+type Exporter struct {
+	Endpoint string            // the traces URL, like http://localhost:4318/v1/traces
+	Headers  map[string]string // added to every export request, like an API key of a hosted collector
+	Service  string            // service.name of the spans
+
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Setup configures the exporter from the standard OpenTelemetry environment variables, tracing stays off without an endpoint
+//
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT  the traces URL
+//	OTEL_EXPORTER_OTLP_ENDPOINT         the collector URL, /v1/traces is added to it
+//	OTEL_EXPORTER_OTLP_HEADERS          headers of the export requests, as key=value,key=value
+//	OTEL_SERVICE_NAME                   defaults to circleci-feedback
+func Setup() error {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		endpoint = strings.TrimSuffix(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/") + "/v1/traces"
+	}
+	if endpoint == "" {
+		exporter = nil
+		return nil
+	}
+
+	headers := map[string]string{}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return fmt.Errorf("Error parsing OTEL_EXPORTER_OTLP_HEADERS, %q is not key=value", pair)
+			}
+			headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "circleci-feedback"
+	}
+
+	exporter = &Exporter{
+		Endpoint:   endpoint,
+		Headers:    headers,
+		Service:    service,
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
+	return nil
+}
+
+// Flush sends the spans ended since the last flush, functions flush at the end of every invocation
+// because lambda can freeze the process as soon as the handler returns
+func Flush() {
+	if exporter == nil {
+		return
+	}
+
+	err := exporter.Export()
+	if err != nil {
+		slog.Error("Error exporting spans", "endpoint", exporter.Endpoint, "error", err)
+	}
+}
+
+func (e *Exporter) add(s *Span) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.spans) < maxBuffered {
+		e.spans = append(e.spans, s)
+	}
+}
+
+// Export sends the buffered spans, they are dropped whether or not the collector took them
+func (e *Exporter) Export() error {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("Error encoding spans, error: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error creating export request, error: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error sending spans, error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("The collector returned %d, %s", resp.StatusCode, msg)
+	}
+
+	return nil
+}
+
+// the OTLP JSON encoding of an ExportTraceServiceRequest, IDs are hex and 64 bit integers are strings
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0 unset, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func (e *Exporter) request(spans []*Span) otlpRequest {
+	resource := []otlpAttribute{attribute("service.name", e.Service)}
+	if fn := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); fn != "" {
+		resource = append(resource, attribute("faas.name", fn))
+	}
+
+	out := []otlpSpan{}
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		}
+		for k, v := range s.attributes {
+			span.Attributes = append(span.Attributes, attribute(k, v))
+		}
+		if s.err != nil {
+			span.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+
+		out = append(out, span)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "circleci-feedback"}, Spans: out}},
+	}}}
+}
+
+func attribute(key string, v interface{}) otlpAttribute {
+	value := map[string]interface{}{}
+	switch v := v.(type) {
+	case int:
+		value["intValue"] = strconv.Itoa(v)
+	case int64:
+		value["intValue"] = strconv.FormatInt(v, 10)
+	case float64:
+		value["doubleValue"] = v
+	case bool:
+		value["boolValue"] = v
+	default:
+		value["stringValue"] = fmt.Sprint(v)
+	}
+
+	return otlpAttribute{Key: key, Value: value}
+}
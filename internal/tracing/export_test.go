@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// collector is an OTLP collector stub that keeps the spans it is sent
+type collector struct {
+	*httptest.Server
+	status int
+
+	mu      sync.Mutex
+	spans   []otlpSpan
+	headers []http.Header
+}
+
+func newCollector(t *testing.T, status int) *collector {
+	c := &collector{status: status}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := otlpRequest{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected OTLP JSON posted to /v1/traces, got %s %s: %v", r.Header.Get("Content-Type"), r.URL.Path, err)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.headers = append(c.headers, r.Header)
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+		w.WriteHeader(c.status)
+	}))
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+// setup points the exporter at the collector like a deployment would through the environment
+func setup(t *testing.T, c *collector) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", c.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=secret")
+	err := Setup()
+	if err != nil {
+		t.Fatalf("Expected the exporter to be set up, got %s", err)
+	}
+	t.Cleanup(func() { exporter = nil })
+}
+
+func TestSpansAreExported(t *testing.T) {
+	c := newCollector(t, 200)
+	setup(t, c)
+
+	ctx, parent := Start(WithTraceParent(context.Background(), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"), "webhook", Server)
+	_, child := Start(ctx, "GET api.github.com", Client)
+	child.SetAttribute("http.response.status_code", 500)
+	child.End(errors.New("failed"))
+	parent.End(nil)
+	Flush()
+
+	if len(c.spans) != 2 {
+		t.Fatalf("Expected the 2 spans to be exported, got %+v", c.spans)
+	}
+	if c.headers[0].Get("x-api-key") != "secret" {
+		t.Errorf("Expected the headers of OTEL_EXPORTER_OTLP_HEADERS, got %v", c.headers[0])
+	}
+
+	got, webhook := c.spans[0], c.spans[1]
+	if webhook.TraceID != "0af7651916cd43dd8448eb211c80319c" || webhook.ParentSpanID != "b7ad6b7169203331" {
+		t.Errorf("Expected the webhook span to continue the trace of the traceparent, got %+v", webhook)
+	}
+	if got.TraceID != webhook.TraceID || got.ParentSpanID != webhook.SpanID || got.Kind != Client {
+		t.Errorf("Expected the request span to be a child of the webhook span, got %+v", got)
+	}
+	if got.Status.Code != 2 || got.Status.Message != "failed" {
+		t.Errorf("Expected the request span to be failed, got %+v", got.Status)
+	}
+	if len(got.Attributes) != 1 || got.Attributes[0].Value["intValue"] != "500" {
+		t.Errorf("Expected the status code as an int attribute, got %+v", got.Attributes)
+	}
+
+	// exported spans aren't sent again
+	Flush()
+	if len(c.headers) != 1 {
+		t.Errorf("Expected nothing to be sent without new spans, got %d requests", len(c.headers))
+	}
+}
+
+func TestCollectorErrors(t *testing.T) {
+	c := newCollector(t, 503)
+	setup(t, c)
+
+	_, span := Start(context.Background(), "webhook", Server)
+	span.End(nil)
+
+	err := exporter.Export()
+	if err == nil {
+		t.Errorf("Expected the status of the collector to be an error")
+	}
+	if len(exporter.spans) != 0 {
+		t.Errorf("Expected the spans to be dropped, got %d", len(exporter.spans))
+	}
+}
+
+func TestContinue(t *testing.T) {
+	ctx, span := Start(context.Background(), "webhook", Server)
+	span.Continue("not a traceparent")
+	if span.ParentID != "" {
+		t.Errorf("Expected a malformed traceparent to be ignored, got parent %s", span.ParentID)
+	}
+
+	span.Continue("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	_, child := Start(ctx, "GET api.github.com", Client)
+	if span.TraceID != "0af7651916cd43dd8448eb211c80319c" || span.ParentID != "b7ad6b7169203331" || child.TraceID != span.TraceID {
+		t.Errorf("Expected the span and its children to continue the trace, got %+v and %+v", span, child)
+	}
+}
@@ -0,0 +1,163 @@
+// Package tracing follows a webhook through the entry function, every task of the step function execution
+// it starts and every CircleCI and GitHub call they make, as spans of one trace
+// Spans are sent to an OpenTelemetry collector with OTLP over HTTP, the W3C traceparent of the entry span is
+// carried from one task to the next in the step function state
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind says what a span is, the values are the OTLP span kinds
+type Kind int
+
+// Span kinds
+const (
+	Internal Kind = 1
+	Server   Kind = 2 // handling a request, a webhook or a step function task
+	Client   Kind = 3 // a request to another service
+)
+
+// Span is one timed operation of a trace
+type Span struct {
+	Name      string
+	Kind      Kind
+	TraceID   string // 32 hex characters
+	SpanID    string // 16 hex characters
+	ParentID  string // empty for the first span of a trace
+	StartTime time.Time
+	EndTime   time.Time
+
+	mu         sync.Mutex
+	attributes map[string]interface{}
+	err        error
+	remote     bool // only the IDs of a span started by another process, it isn't recorded here
+}
+
+type spanKey struct{}
+
+// Start starts a span as a child of the span in ctx, or as the first span of a new trace, and returns a context with it
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	span := &Span{Name: name, Kind: kind, SpanID: newID(8), StartTime: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		parent.mu.Lock()
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		parent.mu.Unlock()
+	} else {
+		span.TraceID = newID(16)
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the span in ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx, or an empty string when there is none
+func TraceParent(ctx context.Context) string {
+	span := FromContext(ctx)
+	if span == nil {
+		return ""
+	}
+
+	return span.TraceParent()
+}
+
+// WithTraceParent returns a context with the span named by traceparent, the spans started from it continue
+// the trace of another process, an empty or malformed traceparent leaves ctx as it is
+func WithTraceParent(ctx context.Context, traceparent string) context.Context {
+	parent := parseTraceParent(traceparent)
+	if parent == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanKey{}, parent)
+}
+
+// Continue makes s, the first span of a trace, a child of the span named by traceparent so it continues the trace
+// of another process, it is for a traceparent that is only trusted once the request it came with is validated
+// Spans already started from s stay in the old trace, an empty or malformed traceparent leaves s as it is
+func (s *Span) Continue(traceparent string) {
+	parent := parseTraceParent(traceparent)
+	if parent == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.TraceID = parent.TraceID
+	s.ParentID = parent.SpanID
+}
+
+// parseTraceParent returns the remote span named by a W3C traceparent, or nil when it is empty or malformed
+func parseTraceParent(traceparent string) *Span {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" || !isHex(parts[1], 32) || !isHex(parts[2], 16) {
+		return nil
+	}
+
+	return &Span{TraceID: parts[1], SpanID: parts[2], remote: true}
+}
+
+// TraceParent formats the IDs of s as a W3C traceparent, every span is sampled
+func (s *Span) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", s.TraceID, s.SpanID)
+}
+
+// SetAttribute adds key to the attributes of s, values are strings, ints, int64s, float64s or bools
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = map[string]interface{}{}
+	}
+	s.attributes[key] = value
+}
+
+// End ends s, a non nil err marks it as failed, the span is exported with the next Flush
+func (s *Span) End(err error) {
+	s.mu.Lock()
+	s.EndTime = time.Now()
+	s.err = err
+	s.mu.Unlock()
+
+	if !s.remote {
+		exporter.add(s)
+	}
+}
+
+func newID(size int) string {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic("tracing: " + err.Error())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// isHex says whether s is n lowercase hex characters and not all zeros, which W3C says is invalid
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Transport sends requests with Base, each as a client span with the traceparent header set
+type Transport struct {
+	Base http.RoundTripper // defaults to http.DefaultTransport
+
+	// Context has the parent span of requests that have none in their own context,
+	// for clients like circleci.Client whose methods don't take a context
+	Context context.Context
+}
+
+// RoundTrip sends req in a span named after its method and host, the query is left out of the recorded
+// url because the CircleCI token is sent in it
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx := req.Context()
+	if FromContext(ctx) == nil && t.Context != nil {
+		if parent := FromContext(t.Context); parent != nil {
+			ctx = context.WithValue(ctx, spanKey{}, parent)
+		}
+	}
+
+	ctx, span := Start(ctx, req.Method+" "+req.URL.Host, Client)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.full", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+
+	// the request of the caller isn't ours to change
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.TraceParent())
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.End(err)
+		return resp, err
+	}
+
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.End(fmt.Errorf("%s", resp.Status))
+	} else {
+		span.End(nil)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,597 @@
+// Package waitforjobs is the step function task that polls the jobs of the pipeline until they are done
+// and reports their failures on the pull request as they come in
+package waitforjobs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/codingdiaz/circleci-feedback/internal/blob"
+	"github.com/codingdiaz/circleci-feedback/internal/codeowners"
+	"github.com/codingdiaz/circleci-feedback/internal/dryrun"
+	"github.com/codingdiaz/circleci-feedback/internal/feedback"
+	"github.com/codingdiaz/circleci-feedback/internal/flaky"
+	"github.com/codingdiaz/circleci-feedback/internal/logging"
+	"github.com/codingdiaz/circleci-feedback/internal/metrics"
+	"github.com/codingdiaz/circleci-feedback/internal/notify"
+	"github.com/codingdiaz/circleci-feedback/internal/pool"
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/tracing"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+	"github.com/codingdiaz/circleci-feedback/pkg/githubapp"
+)
+
+const (
+	defaultMaxInterval = 5 * time.Minute
+	defaultMaxWait     = 3 * time.Hour
+	defaultJitter      = 0.2
+)
+
+// Handler watches the jobs of a pipeline, it is kept across warm invocations so installation tokens are reused
+// until they expire and CircleCI responses with an ETag are revalidated rather than fetched again
+// The main of the function wires in the real services, tests use fakes
+type Handler struct {
+	History flaky.Store // keeps the outcomes of jobs and tests to spot flaky failures
+	Blobs   blob.Store  // keeps the job data that is too big for the step function state
+
+	Config   stepfunc.ConfigSource   // read for every invocation
+	GitHub   githubapp.Installations // posts the reports on pull requests
+	CircleCI stepfunc.CircleCIClients
+	DryRuns  dryrun.Store // keeps the reports on repos in dry run, they are only logged when nil
+}
+
+// Handle is the wait_for_jobs task, it is traced as a child of the webhook that started the execution
+func (h *Handler) Handle(ctx context.Context, in stepfunc.Data) (stepfunc.Data, error) {
+	defer metrics.Flush()
+	defer tracing.Flush()
+
+	ctx, span := in.StartSpan(ctx, "wait_for_jobs")
+	out, err := h.waitForJobs(ctx, in)
+	span.SetAttribute("all_jobs_done", out.AllJobsDone)
+	span.End(err)
+
+	return out, err
+}
+
+// waitForJobs polls the jobs of the pipeline, reports the failures it sees and decides when to look again
+func (h *Handler) waitForJobs(ctx context.Context, in stepfunc.Data) (stepfunc.Data, error) {
+	logger := in.Logger()
+
+	// get configuration for lambda function to run
+	c, err := h.Config.Config()
+	if err != nil {
+		logger.Error("Error getting lambda function configuration", "error", err)
+		return in, fmt.Errorf("Error getting lambda function configuration, error: %s", err)
+	}
+
+	maxInterval, err := stepfunc.DurationFromEnv("WAIT_MAX_INTERVAL", defaultMaxInterval)
+	if err != nil {
+		return in, err
+	}
+	maxWait, err := stepfunc.DurationFromEnv("WAIT_MAX_TOTAL", defaultMaxWait)
+	if err != nil {
+		return in, err
+	}
+	jitter := defaultJitter
+	if v := os.Getenv("WAIT_JITTER"); v != "" {
+		jitter, err = strconv.ParseFloat(v, 64)
+		if err != nil || jitter < 0 || jitter >= 1 {
+			return in, fmt.Errorf("Error parsing WAIT_JITTER, it has to be a fraction between 0 and 1, got %s", v)
+		}
+	}
+
+	if in.WaitForJobsStartedAt.IsZero() {
+		in.WaitForJobsStartedAt = time.Now()
+	}
+
+	// create v2 circleci client, every poll needs current job statuses so nothing is reused without revalidating it
+	metrics.PollIterations.Inc("wait_for_jobs")
+	client := h.CircleCI.Client(ctx, c, logger)
+
+	// if we don't have the workflow ids, get them
+	if len(in.WorkflowIDs) == 0 {
+		// get the full pipeline
+		pipeline, err := client.GetPipeline(in.PipelineID)
+		if err != nil {
+			return in, fmt.Errorf("Error getting pipeline with id %s error: %s", pipeline.ID, err)
+		}
+
+		// add all the workflow ids associated with the pipeline to our stepfunc struct
+		workflows := []string{}
+		for _, workflow := range pipeline.Workflows {
+			workflows = append(workflows, workflow.ID)
+		}
+		in.WorkflowIDs = workflows
+	}
+
+	// the jobs of the last poll say which workflows are done, their jobs can't change so they aren't polled again
+	previous, err := in.LoadJobs(h.Blobs)
+	if err != nil {
+		logger.Error("Error loading the jobs of the last poll, polling every workflow", "error", err)
+		previous = map[string][]circleci.Job{}
+	}
+
+	// if we do have the workflow ids, start checking job information / status
+	polled := make([][]circleci.Job, len(in.WorkflowIDs))
+	err = pool.Run(ctx, c.CircleConcurrency, len(in.WorkflowIDs), func(ctx context.Context, i int) error {
+		if jobs := previous[in.WorkflowIDs[i]]; circleci.WorkflowDone(jobs) {
+			polled[i] = jobs
+			return nil
+		}
+
+		jobs, err := client.WithContext(ctx).GetWorkflowJobs(in.WorkflowIDs[i])
+		if err != nil {
+			return fmt.Errorf("Error getting jobs for workflow with id %v, error: %s", in.WorkflowIDs[i], err)
+		}
+		polled[i] = jobs
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error polling the jobs of the pipeline", "error", err)
+		return in, err
+	}
+
+	// the jobs that aren't done are kept for the report in case we stop watching before they are
+	unfinished := []string{}
+	workflowJobs := map[string][]circleci.Job{}
+	for i, workflow := range in.WorkflowIDs {
+		workflowJobs[workflow] = polled[i]
+
+		for _, job := range polled[i] {
+			if status := previousStatus(previous[workflow], job.ID); status != "" && status != job.Status {
+				logger.Debug("Job status changed", "job", job.Name, "from", status, "to", job.Status)
+			}
+			if !circleci.JobDone(job, polled[i]) {
+				unfinished = append(unfinished, fmt.Sprintf("`%s` (%s)", job.Name, job.Status))
+			}
+		}
+	}
+
+	// only a summary of the jobs is kept in the state, it has to stay under the step function payload limit
+	err = in.SaveJobs(h.Blobs, workflowJobs)
+	if err != nil {
+		logger.Error("Error saving the jobs of the pipeline", "error", err)
+	}
+
+	githubClient, err := h.GitHub.Installation(int64(in.InstallationID))
+	if err != nil {
+		logger.Error("Unable to create authenticated github client", "error", err)
+		return in, fmt.Errorf("Unable to create authenticated github client, error: %s", err)
+	}
+	githubClient = dryrun.Wrap(githubClient, c.Repos.For(int64(in.InstallationID), repoSlug(in)), h.DryRuns, int64(in.InstallationID), logger)
+
+	waited := time.Since(in.WaitForJobsStartedAt)
+	running := len(unfinished) > 0 && waited < maxWait
+
+	// failures are reported as soon as they are seen rather than when the whole pipeline is done,
+	// the workflow names and test results fetched for them are reused for the flaky history below
+	names := map[string]string{}
+	tests := map[string][]circleci.TestResult{}
+	failed := []jobRef{}
+	for _, workflowID := range in.WorkflowIDs {
+		for _, job := range workflowJobs[workflowID] {
+			if job.Status == "failed" && !reported(in, job.ID) {
+				failed = append(failed, jobRef{workflowID: workflowID, job: job})
+			}
+		}
+	}
+
+	err = workflowNames(ctx, client, c, names, failed)
+	if err != nil {
+		logger.Error("Error getting workflow names", "error", err)
+		return in, err
+	}
+	fetchTests(ctx, client, c, in, tests, failed)
+
+	failures := []feedback.Failure{}
+	for _, ref := range failed {
+		failures = append(failures, feedback.NewFailure(names[ref.workflowID], ref.job, tests[ref.job.ID]))
+	}
+
+	// the report is also updated when the last jobs are done, it no longer says it is waiting on them
+	if len(failures) > 0 || (!running && in.ReportCommentID != 0) {
+		logger.Info("Reporting new failures", "failures", len(failures), "running", running)
+		err = h.reportFailures(ctx, &in, failures, running, c, client, githubClient)
+		if err != nil {
+			return in, fmt.Errorf("Error sending build failure to github, %s", err)
+		}
+	}
+
+	// handle backoff retry, update step function input to set as future outputs
+	if running {
+		in.WaitForJobsWaitTime = nextWaitTime(in.WaitForJobsRetryCount, maxInterval, maxWait-waited, jitter)
+		in.WaitForJobsRetryCount = in.WaitForJobsRetryCount + 1
+		in.AllJobsDone = false
+		return in, nil
+	}
+
+	// a pipeline that is still running after the maximum wait is stuck or waiting on someone,
+	// what failed so far is reported and we stop watching
+	in.GaveUp = len(unfinished) > 0
+	if in.GaveUp {
+		logger.Warn("Stopped watching the pipeline before all jobs were done", "waited", waited.String(), "unfinished", len(unfinished))
+	}
+
+	// record every finished job in the flaky history
+	finished := []jobRef{}
+	for _, workflowID := range in.WorkflowIDs {
+		for _, job := range workflowJobs[workflowID] {
+			if circleci.JobDone(job, workflowJobs[workflowID]) {
+				finished = append(finished, jobRef{workflowID: workflowID, job: job})
+			}
+		}
+	}
+
+	err = workflowNames(ctx, client, c, names, finished)
+	if err != nil {
+		logger.Error("Error getting workflow names", "error", err)
+		return in, err
+	}
+	fetchTests(ctx, client, c, in, tests, finished)
+
+	outcomes := []flaky.Outcome{}
+	for _, ref := range finished {
+		outcomes = append(outcomes, feedback.Outcomes(repoSlug(in), in.Branch, in.CommitSHA, names[ref.workflowID], ref.job, tests[ref.job.ID])...)
+	}
+	err = h.History.Record(outcomes)
+	if err != nil {
+		logger.Error("Error recording job outcomes in the flaky history", "error", err)
+	}
+
+	if in.GaveUp {
+		_, err = githubClient.CreateComment(ctx, in.Owner, in.RepoName, in.PullRequestNumber, feedback.GaveUpComment(waited, unfinished))
+		if err != nil {
+			logger.Error("Unable to post a comment on the PR saying we stopped watching", "error", err)
+			return in, fmt.Errorf("Unable to post a comment on the PR saying we stopped watching, error: %s", err)
+		}
+		metrics.CommentsPosted.Inc("gave_up", "create")
+	} else {
+		observePipeline(workflowJobs)
+	}
+
+	in.AllJobsDone = true
+
+	return in, nil
+}
+
+func previousStatus(jobs []circleci.Job, id string) string {
+	for _, job := range jobs {
+		if job.ID == id {
+			return job.Status
+		}
+	}
+
+	return ""
+}
+
+// nextWaitTime is the exponential backoff in seconds for the retry, capped at maxInterval and remaining
+// and spread by up to jitter (a fraction) either way so many executions don't poll in lockstep
+func nextWaitTime(retry int, maxInterval, remaining time.Duration, jitter float64) int {
+	wait := maxInterval
+	if retry < 32 {
+		if backoff := time.Duration(math.Pow(2, float64(retry))) * time.Second; backoff < maxInterval {
+			wait = backoff
+		}
+	}
+
+	wait = time.Duration(float64(wait) * (1 + jitter*(2*rand.Float64()-1)))
+	if wait > remaining {
+		wait = remaining
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+
+	return int(wait.Seconds())
+}
+
+// reportFailures adds failures to the report comment of the pipeline, the comment is created with the first failure
+// running says whether jobs are still watched, the header of the report tells the pull request if more may come
+func (h *Handler) reportFailures(ctx context.Context, in *stepfunc.Data, failures []feedback.Failure, running bool, cfg stepfunc.Config, client circleci.API, githubClient githubapp.API) error {
+	logger := in.Logger()
+
+	// when the base branch is already red, the pull request isn't to blame for the same failures
+	// like the flaky history this only adds context, the report is sent without it when it fails
+	if len(failures) > 0 && in.BaseBranch != "" && in.BaseBranch != in.Branch {
+		base, err := feedback.LatestBranchStatus(ctx, client, cfg.CircleHost, cfg.CircleVCS, in.Owner, in.RepoName, in.BaseBranch, cfg.CircleConcurrency)
+		if err != nil {
+			logger.Error("Error checking the latest pipeline on the base branch", "branch", in.BaseBranch, "error", err)
+		}
+		if base != nil {
+			for i := range failures {
+				base.MarkAlreadyFailing(&failures[i])
+			}
+
+			err = h.History.Record(base.Outcomes)
+			if err != nil {
+				logger.Error("Error recording base branch outcomes in the flaky history", "branch", in.BaseBranch, "error", err)
+			}
+		}
+	}
+
+	// the author of the pull request can have their failures sent somewhere else than the repo's
+	settings := cfg.Repos.ForAuthor(int64(in.InstallationID), repoSlug(*in), in.Author)
+	if len(failures) > 0 && settings.CodeOwners() {
+		owners, err := codeOwners(ctx, *in, githubClient)
+		if err != nil {
+			logger.Error("Error getting the CODEOWNERS of the repo", "error", err)
+		}
+		if owners != nil {
+			for i := range failures {
+				feedback.MarkOwners(owners, &failures[i])
+			}
+		}
+	}
+
+	numbers := []int{}
+	for i := range failures {
+		err := feedback.MarkFlaky(h.History, repoSlug(*in), in.BaseBranch, &failures[i])
+		if err != nil {
+			logger.Error("Error checking if a failure is flaky", "job", failures[i].Job.Name, "error", err)
+		}
+		numbers = append(numbers, failures[i].Job.JobNumber)
+	}
+
+	outputs, err := feedback.FailedOutputs(ctx, client, cfg.CircleVCS, in.Owner, in.RepoName, numbers, cfg.CircleConcurrency)
+	if err != nil {
+		logger.Error("Error getting output of failed builds", "jobs", numbers, "error", err)
+		return fmt.Errorf("Error getting output of failed builds %v, %s", numbers, err)
+	}
+
+	sections := []string{}
+	for i := range failures {
+		sections = append(sections, failures[i].Section(outputs[i]))
+	}
+
+	// the failures reported so far are read back from the comment, they aren't kept in the step function state
+	existing := []string{}
+	if in.ReportCommentID != 0 {
+		comment, err := githubClient.GetComment(ctx, in.Owner, in.RepoName, in.ReportCommentID)
+		if err == githubapp.ErrNotFound {
+			logger.Info("The report comment was deleted, starting a new one", "comment_id", in.ReportCommentID)
+			in.ReportCommentID = 0
+		} else if err != nil {
+			logger.Error("Unable to get the report comment on the PR", "comment_id", in.ReportCommentID, "error", err)
+			return fmt.Errorf("Unable to get the report comment on the PR, error: %s", err)
+		} else {
+			existing = feedback.ReportSections(comment.GetBody())
+		}
+	}
+
+	// sections are added one at a time, a report that can't take the next one is closed and continued in a
+	// new comment, a section on its own always fits
+	report := existing
+	for _, section := range sections {
+		if len(report) > 0 && !feedback.FitsReport(append(report[:len(report):len(report)], section)) {
+			err := postReport(ctx, in, githubClient, report, false)
+			if err != nil {
+				return err
+			}
+			in.ReportCommentID = 0
+			report = []string{}
+		}
+		report = append(report, section)
+	}
+
+	err = postReport(ctx, in, githubClient, report, running)
+	if err != nil {
+		return err
+	}
+
+	// the time from the first of the new failures to the report says how much reporting them early saves
+	first := time.Time{}
+	for _, f := range failures {
+		if !f.Job.StopTime.IsZero() && (first.IsZero() || f.Job.StopTime.Before(first)) {
+			first = f.Job.StopTime
+		}
+	}
+	if !first.IsZero() {
+		metrics.FailureToReport.Since(first)
+	}
+
+	for _, f := range failures {
+		in.ReportedJobs = append(in.ReportedJobs, f.Job.ID)
+	}
+
+	notifyFailures(*in, settings, failures, outputs)
+
+	return nil
+}
+
+// notifyFailures sends new failures to the places configured for the repo, like Slack
+// Like the flaky history they only add to the pull request report, failing to send them is logged and not retried
+func notifyFailures(in stepfunc.Data, settings repoconfig.Settings, failures []feedback.Failure, outputs [][]feedback.FailedOutput) {
+	notifiers := notify.FromSettings(settings)
+	if len(notifiers) == 0 || len(failures) == 0 {
+		return
+	}
+
+	// like the comments, notifications of repos in dry run are only logged
+	if settings.DryRunEnabled() {
+		for _, notifier := range notifiers {
+			in.Logger().Info("Dry run, not sending a notification", "notifier", notifier.String(), "failures", len(failures))
+		}
+		return
+	}
+
+	n := notify.Notification{
+		Repo:        repoSlug(in),
+		PullRequest: in.PullRequestNumber,
+		URL:         pullRequestURL(in),
+		Author:      in.Author,
+		Branch:      in.Branch,
+		SHA:         in.CommitSHA,
+		PipelineID:  in.PipelineID,
+	}
+	for i := range failures {
+		n.Failures = append(n.Failures, notify.NewFailure(failures[i], outputs[i]))
+	}
+
+	for _, notifier := range notifiers {
+		err := notifier.Notify(n)
+		if err != nil {
+			in.Logger().Error("Error sending a notification", "notifier", notifier.String(), "error", err)
+		}
+	}
+}
+
+// postReport edits the report comment to have sections, or creates it when there is none yet
+func postReport(ctx context.Context, in *stepfunc.Data, githubClient githubapp.API, sections []string, running bool) error {
+	body := feedback.Report(sections, running, in.Author)
+	if in.ReportCommentID != 0 {
+		return editReport(ctx, in, githubClient, body)
+	}
+
+	comment, err := githubClient.CreateComment(ctx, in.Owner, in.RepoName, in.PullRequestNumber, body)
+	if err != nil {
+		in.Logger().Error("Unable to post a comment on the PR with the build failure", "error", err)
+		return fmt.Errorf("Unable to post a comment on the PR with the build failure, error: %s", err)
+	}
+	in.ReportCommentID = comment.GetID()
+	metrics.CommentsPosted.Inc("report", "create")
+
+	return nil
+}
+
+func editReport(ctx context.Context, in *stepfunc.Data, githubClient githubapp.API, body string) error {
+	_, err := githubClient.EditComment(ctx, in.Owner, in.RepoName, in.ReportCommentID, body)
+	if err != nil {
+		in.Logger().Error("Unable to update the report comment on the PR", "comment_id", in.ReportCommentID, "error", err)
+		return fmt.Errorf("Unable to update the report comment on the PR, error: %s", err)
+	}
+	metrics.CommentsPosted.Inc("report", "edit")
+
+	return nil
+}
+
+// observePipeline records how long the pipeline took, from the first job starting to the last one stopping
+func observePipeline(workflowJobs map[string][]circleci.Job) {
+	var start, stop time.Time
+	status := "success"
+	for _, jobs := range workflowJobs {
+		for _, job := range jobs {
+			if !job.StartTime.IsZero() && (start.IsZero() || job.StartTime.Before(start)) {
+				start = job.StartTime
+			}
+			if job.StopTime.After(stop) {
+				stop = job.StopTime
+			}
+			if job.Status == "failed" {
+				status = "failed"
+			}
+		}
+	}
+
+	if !start.IsZero() && stop.After(start) {
+		metrics.PipelineDuration.Observe(stop.Sub(start).Seconds(), status)
+	}
+}
+
+// reported reports whether the failure of job is already in the report
+func reported(in stepfunc.Data, jobID string) bool {
+	for _, id := range in.ReportedJobs {
+		if id == jobID {
+			return true
+		}
+	}
+	return false
+}
+
+// jobRef is a job and the workflow it ran in
+type jobRef struct {
+	workflowID string
+	job        circleci.Job
+}
+
+// workflowNames adds the names of the workflows of refs that aren't in names yet, jobs only carry the workflow ID
+func workflowNames(ctx context.Context, client circleci.API, cfg stepfunc.Config, names map[string]string, refs []jobRef) error {
+	ids := []string{}
+	for _, ref := range refs {
+		if _, ok := names[ref.workflowID]; !ok {
+			names[ref.workflowID] = ""
+			ids = append(ids, ref.workflowID)
+		}
+	}
+
+	fetched := make([]string, len(ids))
+	err := pool.Run(ctx, cfg.CircleConcurrency, len(ids), func(ctx context.Context, i int) error {
+		workflow, err := client.WithContext(ctx).GetWorkflow(ids[i])
+		if err != nil {
+			return fmt.Errorf("Error getting workflow with id %v, error: %s", ids[i], err)
+		}
+		fetched[i] = workflow.Name
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		names[id] = fetched[i]
+	}
+
+	return nil
+}
+
+// fetchTests adds the test results of the jobs of refs that aren't in tests yet
+// test results are optional, jobs without store_test_results simply have none
+func fetchTests(ctx context.Context, client circleci.API, cfg stepfunc.Config, in stepfunc.Data, tests map[string][]circleci.TestResult, refs []jobRef) {
+	jobs := []circleci.Job{}
+	for _, ref := range refs {
+		if _, ok := tests[ref.job.ID]; !ok && ref.job.Type == "build" {
+			jobs = append(jobs, ref.job)
+		}
+	}
+
+	fetched := make([][]circleci.TestResult, len(jobs))
+	pool.Run(ctx, cfg.CircleConcurrency, len(jobs), func(ctx context.Context, i int) error {
+		results, err := client.WithContext(ctx).GetJobTests(cfg.CircleVCS, in.Owner, in.RepoName, jobs[i].JobNumber)
+		if err != nil {
+			in.Logger().Warn("Error getting test results of a job", logging.JobNumber, jobs[i].JobNumber, "error", err)
+		}
+		fetched[i] = results
+		return nil
+	})
+
+	for i, job := range jobs {
+		tests[job.ID] = fetched[i]
+	}
+}
+
+// codeOwners gets the CODEOWNERS file of the commit the pipeline built, it returns nil when the repo has none
+func codeOwners(ctx context.Context, in stepfunc.Data, githubClient githubapp.API) (*codeowners.File, error) {
+	for _, path := range codeowners.Locations {
+		content, err := githubClient.GetFile(ctx, in.Owner, in.RepoName, path, in.CommitSHA)
+		if err == githubapp.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return codeowners.Parse(content), nil
+	}
+
+	return nil, nil
+}
+
+// pullRequestURL links to the pull request on the GitHub host the execution was started for
+func pullRequestURL(in stepfunc.Data) string {
+	host := in.GitHubHost
+	if host == "" {
+		host = githubapp.DefaultHost
+	}
+
+	return fmt.Sprintf("https://%s/%s/%s/pull/%d", host, in.Owner, in.RepoName, in.PullRequestNumber)
+}
+
+// repoSlug is the owner/repo name the flaky history is kept under
+func repoSlug(in stepfunc.Data) string {
+	return in.Owner + "/" + in.RepoName
+}
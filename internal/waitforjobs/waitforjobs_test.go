@@ -0,0 +1,294 @@
+package waitforjobs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codingdiaz/circleci-feedback/internal/blob"
+	"github.com/codingdiaz/circleci-feedback/internal/dryrun"
+	"github.com/codingdiaz/circleci-feedback/internal/flaky"
+	"github.com/codingdiaz/circleci-feedback/internal/repoconfig"
+	"github.com/codingdiaz/circleci-feedback/internal/stepfunc"
+	"github.com/codingdiaz/circleci-feedback/internal/testing/fakes"
+	"github.com/codingdiaz/circleci-feedback/pkg/circleci"
+)
+
+func newHandler(circle *fakes.CircleCI, gh *fakes.GitHub) *Handler {
+	return &Handler{
+		History:  flaky.NewMemoryStore(),
+		Blobs:    blob.NewMemoryStore(),
+		Config:   stepfunc.StaticConfig{CircleVCS: "gh", CircleConcurrency: 1},
+		GitHub:   gh,
+		CircleCI: circle,
+	}
+}
+
+func pipeline(jobs ...circleci.Job) *fakes.CircleCI {
+	return &fakes.CircleCI{
+		Pipelines: []circleci.Pipeline{fakes.Pipeline("pipeline-1", "gh/octo/app", "feature", "abc", "workflow-1")},
+		Workflows: map[string]*circleci.Workflow{"workflow-1": {ID: "workflow-1", Name: "build"}},
+		Jobs:      map[string][]circleci.Job{"workflow-1": jobs},
+		Builds:    map[int]*circleci.Build{},
+	}
+}
+
+func input() stepfunc.Data {
+	return stepfunc.Data{Owner: "octo", RepoName: "app", PullRequestNumber: 7, Branch: "feature", CommitSHA: "abc", PipelineID: "pipeline-1", Author: "mona"}
+}
+
+func TestRunningJobs(t *testing.T) {
+	circle := pipeline(circleci.Job{ID: "job-1", Name: "test", JobNumber: 1, Status: "running"})
+	gh := &fakes.GitHub{}
+
+	out, err := newHandler(circle, gh).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.AllJobsDone || out.WaitForJobsWaitTime <= 0 || out.WaitForJobsRetryCount != 1 {
+		t.Errorf("Expected to wait for the running job, done %t, wait %d, retries %d", out.AllJobsDone, out.WaitForJobsWaitTime, out.WaitForJobsRetryCount)
+	}
+	if len(out.WorkflowIDs) != 1 || out.WorkflowIDs[0] != "workflow-1" {
+		t.Errorf("Expected the workflows of the pipeline to be kept, got %v", out.WorkflowIDs)
+	}
+	if len(gh.Comments()) != 0 {
+		t.Errorf("Expected no comments, got %+v", gh.Comments())
+	}
+}
+
+func TestPassingPipeline(t *testing.T) {
+	circle := pipeline(circleci.Job{ID: "job-1", Name: "test", JobNumber: 1, Status: "success"})
+	gh := &fakes.GitHub{}
+
+	out, err := newHandler(circle, gh).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.AllJobsDone || out.GaveUp {
+		t.Errorf("Expected the pipeline to be done, done %t, gave up %t", out.AllJobsDone, out.GaveUp)
+	}
+	if len(gh.Comments()) != 0 {
+		t.Errorf("Expected no comments, got %+v", gh.Comments())
+	}
+}
+
+func TestFailuresAreReportedOnce(t *testing.T) {
+	circle := pipeline(
+		circleci.Job{ID: "job-1", Name: "test", JobNumber: 1, Status: "failed", StopTime: time.Now()},
+		circleci.Job{ID: "job-2", Name: "lint", JobNumber: 2, Status: "running"},
+	)
+	circle.Builds[1] = &circleci.Build{BuildNum: 1}
+	circle.Builds[2] = &circleci.Build{BuildNum: 2}
+	gh := &fakes.GitHub{}
+	h := newHandler(circle, gh)
+
+	out, err := h.Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments := gh.Comments()
+	if len(comments) != 1 || !strings.Contains(comments[0].Body, "`test`") || out.ReportCommentID != comments[0].ID {
+		t.Fatalf("Expected a report of test, got %+v", comments)
+	}
+
+	// the next poll only adds the new failure to the report
+	circle.Jobs["workflow-1"][1].Status = "failed"
+	out, err = h.Handle(context.Background(), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments = gh.Comments()
+	if len(comments) != 1 || strings.Count(comments[0].Body, "`test`") != 1 || !strings.Contains(comments[0].Body, "`lint`") {
+		t.Errorf("Expected the report to have test once and lint, got %+v", comments)
+	}
+	if !out.AllJobsDone || len(out.ReportedJobs) != 2 {
+		t.Errorf("Expected both jobs to be reported, done %t, reported %v", out.AllJobsDone, out.ReportedJobs)
+	}
+}
+
+func TestBlockedAndCanceledJobsAreDone(t *testing.T) {
+	circle := pipeline(
+		circleci.Job{ID: "job-1", Name: "test", JobNumber: 1, Status: "failed", StopTime: time.Now()},
+		circleci.Job{ID: "job-2", Name: "deploy", JobNumber: 2, Status: "blocked"},
+		circleci.Job{ID: "job-3", Name: "lint", JobNumber: 3, Status: "canceled"},
+	)
+	circle.Builds[1] = &circleci.Build{BuildNum: 1}
+	gh := &fakes.GitHub{}
+	h := newHandler(circle, gh)
+
+	out, err := h.Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !out.AllJobsDone || out.GaveUp {
+		t.Errorf("Expected the pipeline to be done, done %t, gave up %t", out.AllJobsDone, out.GaveUp)
+	}
+	comments := gh.Comments()
+	if len(comments) != 1 || strings.Contains(comments[0].Body, "Stopped watching") {
+		t.Errorf("Expected only the report of test, got %+v", comments)
+	}
+	if runs, _ := h.History.Recent("octo/app", flaky.JobName("build", "lint"), 10); len(runs) != 0 {
+		t.Errorf("Expected the canceled job to stay out of the flaky history, got %+v", runs)
+	}
+}
+
+func TestBlockedJobsWaitOnRunningOnes(t *testing.T) {
+	circle := pipeline(
+		circleci.Job{ID: "job-1", Name: "test", JobNumber: 1, Status: "running"},
+		circleci.Job{ID: "job-2", Name: "deploy", JobNumber: 2, Status: "blocked"},
+	)
+
+	out, err := newHandler(circle, &fakes.GitHub{}).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.AllJobsDone {
+		t.Errorf("Expected to wait for the running job and the job it blocks")
+	}
+}
+
+func TestGivingUp(t *testing.T) {
+	t.Setenv("WAIT_MAX_TOTAL", "1ns")
+	circle := pipeline(circleci.Job{ID: "job-1", Name: "deploy", JobNumber: 1, Status: "on_hold"})
+	gh := &fakes.GitHub{}
+
+	out, err := newHandler(circle, gh).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.AllJobsDone || !out.GaveUp {
+		t.Errorf("Expected to give up, done %t, gave up %t", out.AllJobsDone, out.GaveUp)
+	}
+	comments := gh.Comments()
+	if len(comments) != 1 || !strings.Contains(comments[0].Body, "`deploy` (on_hold)") {
+		t.Errorf("Expected a comment naming the unfinished job, got %+v", comments)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	circle := pipeline(
+		circleci.Job{ID: "job-1", Name: "test", JobNumber: 1, Status: "failed", StopTime: time.Now()},
+		circleci.Job{ID: "job-2", Name: "lint", JobNumber: 2, Status: "running"},
+	)
+	circle.Builds[1] = &circleci.Build{BuildNum: 1}
+	circle.Builds[2] = &circleci.Build{BuildNum: 2}
+	gh := &fakes.GitHub{}
+	h := newHandler(circle, gh)
+
+	var notified int32
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&notified, 1)
+	}))
+	defer hooks.Close()
+
+	dryRun := true
+	h.Config = stepfunc.StaticConfig{CircleVCS: "gh", CircleConcurrency: 1, Repos: &repoconfig.File{
+		Repos: map[string]repoconfig.Settings{"octo/app": {
+			DryRun:   &dryRun,
+			Slack:    &repoconfig.Slack{WebhookURL: hooks.URL + "/slack"},
+			Webhooks: []repoconfig.Webhook{{URL: hooks.URL + "/webhook", Secret: "secret"}},
+		}},
+	}}
+	store := dryrun.NewMemoryStore()
+	h.DryRuns = store
+
+	out, err := h.Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	circle.Jobs["workflow-1"][1].Status = "failed"
+	out, err = h.Handle(context.Background(), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gh.Comments()) != 0 {
+		t.Errorf("Expected nothing to be posted in dry run, got %+v", gh.Comments())
+	}
+	if notified != 0 {
+		t.Errorf("Expected no notifications in dry run, got %d", notified)
+	}
+	kept, err := store.Recent("octo/app", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || kept[0].ID != out.ReportCommentID || kept[0].Edits != 1 || kept[0].Number != 7 {
+		t.Fatalf("Expected the report to be kept and edited once, got %+v", kept)
+	}
+	if !strings.Contains(kept[0].Body, "`test`") || !strings.Contains(kept[0].Body, "`lint`") {
+		t.Errorf("Expected the kept report to have test and lint, got %s", kept[0].Body)
+	}
+}
+
+func TestLargeReportsAreSplit(t *testing.T) {
+	jobs := []circleci.Job{}
+	for i := 1; i <= 8; i++ {
+		jobs = append(jobs, circleci.Job{ID: fmt.Sprintf("job-%d", i), Name: fmt.Sprintf("test-%d", i), JobNumber: i, Status: "failed", StopTime: time.Now()})
+	}
+	circle := pipeline(jobs...)
+	circle.Outputs = map[string]string{}
+	for _, job := range jobs {
+		url := fmt.Sprintf("https://output/%d", job.JobNumber)
+		circle.Builds[job.JobNumber] = &circleci.Build{BuildNum: job.JobNumber, Steps: []*circleci.Step{{
+			Name:    "go test",
+			Actions: []*circleci.Action{{Status: "failed", OutputURL: url}},
+		}}}
+		circle.Outputs[url] = strings.Repeat("x", 20000)
+	}
+	gh := &fakes.GitHub{}
+
+	_, err := newHandler(circle, gh).Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comments := gh.Comments()
+	if len(comments) < 2 {
+		t.Fatalf("Expected the report to be split over comments, got %d", len(comments))
+	}
+	all := ""
+	for _, c := range comments {
+		if len(c.Body) > 65536 {
+			t.Errorf("Expected every comment to fit GitHub's limit, one is %d long", len(c.Body))
+		}
+		all = all + c.Body
+	}
+	for _, job := range jobs {
+		if strings.Count(all, "`"+job.Name+"`") != 1 {
+			t.Errorf("Expected %s to be reported once", job.Name)
+		}
+	}
+}
+
+func TestDoneWorkflowsAreNotPolledAgain(t *testing.T) {
+	circle := pipeline(circleci.Job{ID: "job-1", Name: "lint", JobNumber: 1, Status: "success"})
+	circle.Pipelines[0] = fakes.Pipeline("pipeline-1", "gh/octo/app", "feature", "abc", "workflow-1", "workflow-2")
+	circle.Workflows["workflow-2"] = &circleci.Workflow{ID: "workflow-2", Name: "deploy"}
+	circle.Jobs["workflow-2"] = []circleci.Job{{ID: "job-2", Name: "deploy", JobNumber: 2, Status: "running"}}
+	h := newHandler(circle, &fakes.GitHub{})
+
+	out, err := h.Handle(context.Background(), input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Jobs.Total != 2 || out.Jobs.Done != 1 || out.Jobs.Key == "" {
+		t.Fatalf("Expected the jobs to be summarized and stored, got %+v", out.Jobs)
+	}
+
+	// the jobs of the done workflow come from the blob store, CircleCI would fail the request for them
+	delete(circle.Jobs, "workflow-1")
+	circle.Jobs["workflow-2"][0].Status = "success"
+	out, err = h.Handle(context.Background(), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.AllJobsDone || out.Jobs.Done != 2 {
+		t.Errorf("Expected both workflows to be done, done %t, jobs %+v", out.AllJobsDone, out.Jobs)
+	}
+}
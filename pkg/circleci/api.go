@@ -0,0 +1,28 @@
+package circleci
+
+import "context"
+
+// API is the CircleCI API, Client implements it
+// Code that takes an API rather than a *Client can be tested with a fake CircleCI
+type API interface {
+	GetBuild(vcs, account, repo string, buildNum int) (*Build, error)
+	Me() (*User, error)
+	GetProject(vcsProvider, account, repo string) (*Project, error)
+	GetWorkflow(workflowID string) (*Workflow, error)
+	GetWorkflowJobs(workflowID string) ([]Job, error)
+	GetProjectPipelines(vcsProvider, account, repo string) ([]Pipeline, error)
+	FindPipelines(vcsProvider, account, repo string, opts FindPipelinesOptions) ([]Pipeline, error)
+	GetJobTests(vcsProvider, account, repo string, jobNumber int) ([]TestResult, error)
+	GetPipeline(pipelineID string) (*Pipeline, error)
+	RerunWorkflow(workflowID string, opts RerunWorkflowOptions) (*RerunWorkflowResponse, error)
+	CancelWorkflow(workflowID string) error
+	CancelJob(vcsProvider, account, repo string, jobNumber int) error
+	ApproveJob(workflowID, approvalRequestID string) error
+	TriggerPipeline(vcsProvider, account, repo string, opts TriggerPipelineOptions) (*PipelineCreated, error)
+	StepLogs(job JobRef) (*JobLogs, error)
+	StepOutput(log StepLog) (string, error)
+	// WithContext returns the API with its requests made with ctx
+	WithContext(ctx context.Context) API
+}
+
+var _ API = (*Client)(nil)
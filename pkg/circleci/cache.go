@@ -0,0 +1,116 @@
+package circleci
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// maxCacheEntries bounds how many responses a Cache keeps between resets
+const maxCacheEntries = 1000
+
+// Cache keeps the GET responses of one or more Clients so repeated requests don't all reach CircleCI
+//
+// A response is reused as is until Reset, which marks the start of a new lambda invocation or anything
+// else that needs current data. After a reset responses that came with an ETag are revalidated with
+// If-None-Match, a 304 costs CircleCI no rate limit and sends no body, the others are fetched again.
+// Identical requests made at the same time are sent once and share the response, unless the request
+// failed because the context of the caller that sent it was done, then the others send it again.
+type Cache struct {
+	mu       sync.Mutex
+	entries  map[string]*cacheEntry
+	inflight map[string]*cacheCall
+}
+
+type cacheEntry struct {
+	etag  string
+	body  []byte
+	fresh bool // fetched or revalidated since the last reset
+}
+
+type cacheCall struct {
+	done     chan struct{}
+	body     []byte
+	err      error
+	canceled bool // err came from the context of the caller that sent the request
+}
+
+// NewCache returns an empty Cache
+func NewCache() *Cache {
+	return &Cache{entries: map[string]*cacheEntry{}, inflight: map[string]*cacheCall{}}
+}
+
+// Reset stops reusing responses without revalidating them first, responses without an ETag are forgotten
+func (c *Cache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) > maxCacheEntries {
+		c.entries = map[string]*cacheEntry{}
+		return
+	}
+
+	for key, e := range c.entries {
+		if e.etag == "" {
+			delete(c.entries, key)
+			continue
+		}
+		e.fresh = false
+	}
+}
+
+// fetchFunc sends a request, with If-None-Match set to ifNoneMatch when it isn't empty,
+// and returns the status, ETag and body of a successful response
+type fetchFunc func(ifNoneMatch string) (status int, etag string, body []byte, err error)
+
+// get returns the response body for key, from the cache when it is fresh and from fetch otherwise,
+// ctx is the context fetch sends the request with
+func (c *Cache) get(ctx context.Context, key string, fetch fetchFunc) ([]byte, error) {
+	for {
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok && e.fresh {
+			c.mu.Unlock()
+			return e.body, nil
+		}
+		call, ok := c.inflight[key]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !call.canceled {
+			return call.body, call.err
+		}
+		// the caller that sent the request gave up, that says nothing about this one
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	cached := &cacheEntry{}
+	if e, ok := c.entries[key]; ok {
+		cached = e
+	}
+	c.mu.Unlock()
+
+	status, etag, body, err := fetch(cached.etag)
+	if err == nil && status == http.StatusNotModified {
+		etag, body = cached.etag, cached.body
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if err == nil {
+		c.entries[key] = &cacheEntry{etag: etag, body: body, fresh: true}
+	}
+	c.mu.Unlock()
+
+	call.body, call.err = body, err
+	call.canceled = err != nil && ctx.Err() != nil
+	close(call.done)
+	return body, err
+}
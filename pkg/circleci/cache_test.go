@@ -0,0 +1,101 @@
+package circleci
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitersRetryWhenTheFirstCallerGivesUp(t *testing.T) {
+	c := NewCache()
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+
+	first := make(chan error)
+	go func() {
+		_, err := c.get(ctx, "pipelines", func(string) (int, string, []byte, error) {
+			close(started)
+			<-ctx.Done()
+			return 0, "", nil, ctx.Err()
+		})
+		first <- err
+	}()
+	<-started
+
+	waiter := make(chan []byte)
+	go func() {
+		body, err := c.get(context.Background(), "pipelines", func(string) (int, string, []byte, error) {
+			return 200, "", []byte("ok"), nil
+		})
+		if err != nil {
+			t.Errorf("Expected the waiter to send the request again, got %s", err)
+		}
+		waiter <- body
+	}()
+	// let the waiter start waiting on the request of the first caller
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Expected the first caller to get its context error, got %v", err)
+	}
+	if body := <-waiter; string(body) != "ok" {
+		t.Errorf("Expected the waiter to get its own response, got %q", body)
+	}
+}
+
+func TestWaitersShareOtherErrors(t *testing.T) {
+	c := NewCache()
+	failed := errors.New("unavailable")
+	release := make(chan struct{})
+	var fetches int32
+	fetch := func(string) (int, string, []byte, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return 0, "", nil, failed
+	}
+
+	errs := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := c.get(context.Background(), "pipelines", fetch)
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != failed {
+			t.Errorf("Expected the error of the request, got %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected the request to be sent once, it was sent %d times", fetches)
+	}
+}
+
+func TestWaitersStopWithTheirContext(t *testing.T) {
+	c := NewCache()
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go c.get(context.Background(), "pipelines", func(string) (int, string, []byte, error) {
+		close(started)
+		<-release
+		return 200, "", []byte("ok"), nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := c.get(ctx, "pipelines", func(string) (int, string, []byte, error) {
+		t.Errorf("Expected the waiter not to send the request")
+		return 0, "", nil, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the waiter to stop at its deadline, got %v", err)
+	}
+}
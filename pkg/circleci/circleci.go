@@ -2,15 +2,18 @@ package circleci
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -18,14 +21,59 @@ const (
 	queryLimit = 100 // maximum that CircleCI allows
 )
 
-var (
-	defaultBaseURL = &url.URL{Host: "circleci.com", Scheme: "https", Path: "/api/v2/"}
-	defaultLogger  = log.New(os.Stderr, "", log.LstdFlags)
+// VCS types CircleCI uses in project slugs
+const (
+	VCSGitHub    = "gh"
+	VCSBitbucket = "bb"
 )
 
-// Logger is a minimal interface for injecting custom logging logic for debug logs
-type Logger interface {
-	Printf(fmt string, args ...interface{})
+// VCSSlug returns the project slug VCS type for vcsType, it accepts the slug form (gh, bb) as well as
+// the provider names, GitHub Enterprise Server projects use the same slug as github.com projects
+func VCSSlug(vcsType string) (string, error) {
+	switch strings.ToLower(vcsType) {
+	case "", VCSGitHub, "github", "github-enterprise", "ghe":
+		return VCSGitHub, nil
+	case VCSBitbucket, "bitbucket", "bitbucket-server":
+		return VCSBitbucket, nil
+	default:
+		return "", fmt.Errorf("unknown CircleCI VCS type %q", vcsType)
+	}
+}
+
+// ProjectSlug returns the v2 project slug for a repo, for example gh/codingdiaz/circleci-feedback
+func ProjectSlug(vcs, account, repo string) string {
+	return fmt.Sprintf("%s/%s/%s", vcs, account, repo)
+}
+
+// DefaultHost is the host of CircleCI cloud, CircleCI server installs have a host of their own
+const DefaultHost = "circleci.com"
+
+// BaseURL returns the v2 API endpoint of a CircleCI host, the host can be a URL like http://localhost:8080
+func BaseURL(host string) *url.URL {
+	u := hostURL(host)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/"
+	return u
+}
+
+// AppURL returns the URL of the web app of a CircleCI host, cloud has it on its own subdomain
+func AppURL(host string) string {
+	u := hostURL(host)
+	if u.Host == DefaultHost {
+		u.Host = "app." + DefaultHost
+	}
+
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+func hostURL(host string) *url.URL {
+	if host == "" {
+		host = DefaultHost
+	}
+	if u, err := url.Parse(host); err == nil && u.Scheme != "" && u.Host != "" {
+		return u
+	}
+
+	return &url.URL{Scheme: "https", Host: host}
 }
 
 // APIError represents an error from CircleCI
@@ -41,22 +89,50 @@ func (e *APIError) Error() string {
 // Client is a CircleCI client
 // Its zero value is a usable client for examining public CircleCI repositories
 type Client struct {
-	BaseURL    *url.URL     // CircleCI API endpoint (defaults to DefaultEndpoint)
+	BaseURL    *url.URL     // CircleCI v2 API endpoint (defaults to the one of DefaultHost), see BaseURL
 	Token      string       // CircleCI API token (needed for private repositories and mutative actions)
 	HTTPClient *http.Client // HTTPClient to use for connecting to CircleCI (defaults to http.DefaultClient)
 
-	Debug  bool   // debug logging enabled
-	Logger Logger // logger to send debug messages on (if enabled), defaults to logging to stderr with the standard flags
+	Logger *slog.Logger // logger for debug messages with every request and response, defaults to slog.Default()
+
+	Cache *Cache // reuses GET responses when set, see Cache for when they are reused
+
+	ctx context.Context // requests are made with it, see WithContext
+}
+
+// WithContext returns a copy of the client whose requests are made with ctx, canceling ctx cancels them
+func (c *Client) WithContext(ctx context.Context) API {
+	return &Client{
+		BaseURL:    c.BaseURL,
+		Token:      c.Token,
+		HTTPClient: c.HTTPClient,
+		Logger:     c.Logger,
+		Cache:      c.Cache,
+		ctx:        ctx,
+	}
+}
+
+func (c *Client) requestContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
 }
 
 func (c *Client) baseURL() *url.URL {
 	if c.BaseURL == nil {
-		return defaultBaseURL
+		return BaseURL(DefaultHost)
 	}
 
 	return c.BaseURL
 }
 
+// v1URL is the v1.1 API endpoint next to the v2 one, a client that was given a v1.1 endpoint keeps it
+func (c *Client) v1URL() *url.URL {
+	return c.baseURL().ResolveReference(&url.URL{Path: "../v1.1/"})
+}
+
 func (c *Client) client() *http.Client {
 	if c.HTTPClient == nil {
 		return http.DefaultClient
@@ -65,32 +141,43 @@ func (c *Client) client() *http.Client {
 	return c.HTTPClient
 }
 
-func (c *Client) logger() Logger {
+func (c *Client) logger() *slog.Logger {
 	if c.Logger == nil {
-		return defaultLogger
+		return slog.Default()
 	}
 
 	return c.Logger
 }
 
+func (c *Client) debugEnabled() bool {
+	return c.logger().Enabled(context.Background(), slog.LevelDebug)
+}
+
 func (c *Client) debug(format string, args ...interface{}) {
-	if c.Debug {
-		c.logger().Printf(format, args...)
+	if c.debugEnabled() {
+		c.logger().Debug(fmt.Sprintf(format, args...))
 	}
 }
 
+// tokenParam is the token in request URLs, it is redacted from debug messages
+var tokenParam = regexp.MustCompile(`circle-token=[^&\s]*`)
+
+func redact(s string) string {
+	return tokenParam.ReplaceAllString(s, "circle-token=REDACTED")
+}
+
 func (c *Client) debugRequest(req *http.Request) {
-	if c.Debug {
+	if c.debugEnabled() {
 		out, err := httputil.DumpRequestOut(req, true)
 		if err != nil {
-			c.debug("error debugging request %+v: %s", req, err)
+			c.debug("error debugging request to %s: %s", redact(req.URL.String()), err)
 		}
-		c.debug("request:\n%+v", string(out))
+		c.debug("request:\n%+v", redact(string(out)))
 	}
 }
 
 func (c *Client) debugResponse(resp *http.Response) {
-	if c.Debug {
+	if c.debugEnabled() {
 		out, err := httputil.DumpResponse(resp, true)
 		if err != nil {
 			c.debug("error debugging response %+v: %s", resp, err)
@@ -99,53 +186,96 @@ func (c *Client) debugResponse(resp *http.Response) {
 	}
 }
 
-type nopCloser struct {
-	io.Reader
+func (c *Client) request(method, path string, responseStruct interface{}, params url.Values, bodyStruct interface{}) error {
+	return c.requestURL(method, c.baseURL(), path, responseStruct, params, bodyStruct)
 }
 
-func (n nopCloser) Close() error { return nil }
-
-func (c *Client) request(method, path string, responseStruct interface{}, params url.Values, bodyStruct interface{}) error {
+// requestURL is request against the API endpoint base, for the v1.1 endpoints the v2 client also calls
+func (c *Client) requestURL(method string, base *url.URL, path string, responseStruct interface{}, params url.Values, bodyStruct interface{}) error {
 	if params == nil {
 		params = url.Values{}
 	}
 	params.Set("circle-token", c.Token)
 
-	u := c.baseURL().ResolveReference(&url.URL{Path: path, RawQuery: params.Encode()})
+	u := base.ResolveReference(&url.URL{Path: path, RawQuery: params.Encode()})
+
+	c.debug("building request for %s", redact(u.String()))
 
-	c.debug("building request for %s", u)
+	var body []byte
+	if bodyStruct != nil {
+		b, err := json.Marshal(bodyStruct)
+		if err != nil {
+			return err
+		}
+
+		body = b
+	}
 
-	req, err := http.NewRequest(method, u.String(), nil)
+	var b []byte
+	var err error
+	switch {
+	case c.Cache != nil && method == http.MethodGet:
+		b, err = c.Cache.get(c.requestContext(), u.String(), func(etag string) (int, string, []byte, error) {
+			return c.send(method, u, nil, etag)
+		})
+	default:
+		// anything that changes something in CircleCI can change what earlier requests returned
+		if c.Cache != nil {
+			c.Cache.Reset()
+		}
+		_, _, b, err = c.send(method, u, body, "")
+	}
 	if err != nil {
 		return err
 	}
 
-	if bodyStruct != nil {
-		b, err := json.Marshal(bodyStruct)
-		if err != nil {
+	if responseStruct != nil {
+		// some mutating endpoints answer without a body
+		err = json.NewDecoder(bytes.NewReader(b)).Decode(responseStruct)
+		if err != nil && err != io.EOF {
 			return err
 		}
+	}
 
-		req.Body = nopCloser{bytes.NewBuffer(b)}
+	return nil
+}
+
+// send sends a request and returns the status, ETag and body of a successful response (or a 304 when etag is set)
+func (c *Client) send(method string, u *url.URL, body []byte, etag string) (int, string, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(c.requestContext(), method, u.String(), reader)
+	if err != nil {
+		return 0, "", nil, err
 	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
+	if etag != "" {
+		req.Header.Add("If-None-Match", etag)
+	}
 
 	c.debugRequest(req)
 
 	resp, err := c.client().Do(req)
 	if err != nil {
-		return err
+		return 0, "", nil, err
 	}
 	defer resp.Body.Close()
 
 	c.debugResponse(resp)
 
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return resp.StatusCode, etag, nil, nil
+	}
+
 	if resp.StatusCode >= 300 {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return &APIError{HTTPStatusCode: resp.StatusCode, Message: "unable to parse response: %s"}
+			return 0, "", nil, &APIError{HTTPStatusCode: resp.StatusCode, Message: "unable to parse response: %s"}
 		}
 
 		if len(body) > 0 {
@@ -154,32 +284,30 @@ func (c *Client) request(method, path string, responseStruct interface{}, params
 			}{}
 			err = json.Unmarshal(body, &message)
 			if err != nil {
-				return &APIError{
+				return 0, "", nil, &APIError{
 					HTTPStatusCode: resp.StatusCode,
 					Message:        fmt.Sprintf("unable to parse API response: %s", err),
 				}
 			}
-			return &APIError{HTTPStatusCode: resp.StatusCode, Message: message.Message}
+			return 0, "", nil, &APIError{HTTPStatusCode: resp.StatusCode, Message: message.Message}
 		}
 
-		return &APIError{HTTPStatusCode: resp.StatusCode}
+		return 0, "", nil, &APIError{HTTPStatusCode: resp.StatusCode}
 	}
 
-	if responseStruct != nil {
-		err = json.NewDecoder(resp.Body).Decode(responseStruct)
-		if err != nil {
-			return err
-		}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", nil, err
 	}
 
-	return nil
+	return resp.StatusCode, resp.Header.Get("ETag"), b, nil
 }
 
-// GetBuild fetches a given build by number
+// GetBuild fetches a given build by number with the v1.1 API
 func (c *Client) GetBuild(vcs, account, repo string, buildNum int) (*Build, error) {
 	build := &Build{}
 
-	err := c.request("GET", fmt.Sprintf("project/%s/%s/%s/%d", vcs, account, repo, buildNum), build, nil, nil)
+	err := c.requestURL("GET", c.v1URL(), fmt.Sprintf("project/%s/%s/%s/%d", vcs, account, repo, buildNum), build, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -203,7 +331,7 @@ func (c *Client) Me() (*User, error) {
 func (c *Client) GetProject(vcsProvider, account, repo string) (*Project, error) {
 	project := &Project{}
 
-	err := c.request("GET", fmt.Sprintf("project/%s/%s/%s", vcsProvider, account, repo), project, nil, nil)
+	err := c.request("GET", "project/"+ProjectSlug(vcsProvider, account, repo), project, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -238,7 +366,7 @@ func (c *Client) GetWorkflowJobs(workflowID string) ([]Job, error) {
 func (c *Client) GetProjectPipelines(vcsProvider, account, repo string) ([]Pipeline, error) {
 	resp := &GetProjectPipelinesResponse{}
 
-	err := c.request("GET", fmt.Sprintf("project/%s/%s/%s/pipeline", vcsProvider, account, repo), resp, nil, nil)
+	err := c.request("GET", "project/"+ProjectSlug(vcsProvider, account, repo)+"/pipeline", resp, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -246,6 +374,80 @@ func (c *Client) GetProjectPipelines(vcsProvider, account, repo string) ([]Pipel
 	return resp.Items, nil
 }
 
+// defaultPipelinePages is how many pages FindPipelines walks when no limit is given
+const defaultPipelinePages = 5
+
+// FindPipelinesOptions narrows down the pipelines FindPipelines returns
+type FindPipelinesOptions struct {
+	Branch   string // only look at pipelines of this branch, empty looks at every branch
+	Revision string // only return pipelines that built this commit, empty returns every pipeline
+	MaxPages int    // how many pages of pipelines to walk, defaults to 5
+}
+
+// FindPipelines walks the recent pipelines of a project with the V2 API and returns the ones matching opts, newest first
+// A commit can have more than one pipeline, for example when a pipeline is triggered again through the API
+// An empty result means CircleCI hasn't created a matching pipeline (yet)
+func (c *Client) FindPipelines(vcsProvider, account, repo string, opts FindPipelinesOptions) ([]Pipeline, error) {
+	maxPages := opts.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultPipelinePages
+	}
+
+	found := []Pipeline{}
+	pageToken := ""
+	for page := 0; page < maxPages; page++ {
+		params := url.Values{}
+		if opts.Branch != "" {
+			params.Set("branch", opts.Branch)
+		}
+		if pageToken != "" {
+			params.Set("page-token", pageToken)
+		}
+
+		resp := &GetProjectPipelinesResponse{}
+		err := c.request("GET", "project/"+ProjectSlug(vcsProvider, account, repo)+"/pipeline", resp, params, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pipeline := range resp.Items {
+			if opts.Revision == "" || pipeline.Vcs.Revision == opts.Revision {
+				found = append(found, pipeline)
+			}
+		}
+
+		// pipelines come newest first and the pipelines of a commit are created close together,
+		// so there is no need to look further than the page holding the first match
+		if resp.NextPageToken == "" || (opts.Revision != "" && len(found) > 0) {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].CreatedAt.After(found[j].CreatedAt) })
+
+	return found, nil
+}
+
+// GetJobTests gets the test results a job stored with store_test_results with the V2 API, following every page
+func (c *Client) GetJobTests(vcsProvider, account, repo string, jobNumber int) ([]TestResult, error) {
+	tests := []TestResult{}
+	params := url.Values{}
+	for {
+		resp := &GetJobTestsResponse{}
+		err := c.request("GET", fmt.Sprintf("project/%s/%d/tests", ProjectSlug(vcsProvider, account, repo), jobNumber), resp, params, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		tests = append(tests, resp.Items...)
+		if resp.NextPageToken == "" {
+			return tests, nil
+		}
+		params = url.Values{"page-token": []string{resp.NextPageToken}}
+	}
+}
+
 // GetPipeline gets a specific Pipeline with the V2 API
 func (c *Client) GetPipeline(pipelineID string) (*Pipeline, error) {
 	pipeline := &Pipeline{}
@@ -257,32 +459,85 @@ func (c *Client) GetPipeline(pipelineID string) (*Pipeline, error) {
 	return pipeline, nil
 }
 
-func GetBuildOutput(buildOutputURL string) ([]BuildOutput, error) {
+// RerunWorkflowOptions selects what part of a workflow RerunWorkflow reruns, the zero value reruns the whole workflow
+type RerunWorkflowOptions struct {
+	FromFailed bool     `json:"from_failed,omitempty"` // only rerun the failed jobs and the jobs depending on them
+	Jobs       []string `json:"jobs,omitempty"`        // only rerun the jobs with these IDs
+	SparseTree bool     `json:"sparse_tree,omitempty"` // with Jobs, skip the jobs the selected jobs depend on
+}
 
-	output := &[]BuildOutput{}
+// RerunWorkflowResponse is the workflow a rerun created
+type RerunWorkflowResponse struct {
+	WorkflowID string `json:"workflow_id"`
+}
 
-	req, err := http.NewRequest("GET", buildOutputURL, nil)
-	if err != nil {
-		return nil, nil
+// RerunWorkflow reruns a workflow with the V2 API, a rerun is a new workflow in the same pipeline
+func (c *Client) RerunWorkflow(workflowID string, opts RerunWorkflowOptions) (*RerunWorkflowResponse, error) {
+	if opts.FromFailed && len(opts.Jobs) > 0 {
+		return nil, fmt.Errorf("a workflow can be rerun from failed or with selected jobs, not both")
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	resp := &RerunWorkflowResponse{}
+	err := c.request("POST", fmt.Sprintf("workflow/%s/rerun", workflowID), resp, nil, opts)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, nil
+	return resp, nil
+}
+
+// CancelWorkflow cancels a running workflow with the V2 API
+func (c *Client) CancelWorkflow(workflowID string) error {
+	return c.request("POST", fmt.Sprintf("workflow/%s/cancel", workflowID), nil, nil, nil)
+}
+
+// CancelJob cancels a running job with the V2 API
+func (c *Client) CancelJob(vcsProvider, account, repo string, jobNumber int) error {
+	return c.request("POST", fmt.Sprintf("project/%s/job/%d/cancel", ProjectSlug(vcsProvider, account, repo), jobNumber), nil, nil, nil)
+}
+
+// ApproveJob approves an approval job with the V2 API, approvalRequestID is the ApprovalRequestID of the job
+// The jobs waiting on the approval run in the same workflow
+func (c *Client) ApproveJob(workflowID, approvalRequestID string) error {
+	return c.request("POST", fmt.Sprintf("workflow/%s/approve/%s", workflowID, approvalRequestID), nil, nil, nil)
+}
+
+// TriggerPipelineOptions are the options of a pipeline started with TriggerPipeline
+// Only one of Branch and Tag can be set, with neither the project's default branch is built
+type TriggerPipelineOptions struct {
+	Branch     string                 `json:"branch,omitempty"`
+	Tag        string                 `json:"tag,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// PipelineCreated is the pipeline TriggerPipeline started, its workflows only exist once CircleCI has processed the config
+type PipelineCreated struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	Number    int       `json:"number"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TriggerPipeline starts a new pipeline for a project with the V2 API
+func (c *Client) TriggerPipeline(vcsProvider, account, repo string, opts TriggerPipelineOptions) (*PipelineCreated, error) {
+	if opts.Branch != "" && opts.Tag != "" {
+		return nil, fmt.Errorf("a pipeline can be triggered for a branch or a tag, not both")
 	}
 
-	err = json.Unmarshal(body, &output)
+	pipeline := &PipelineCreated{}
+	err := c.request("POST", "project/"+ProjectSlug(vcsProvider, account, repo)+"/pipeline", pipeline, nil, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return *output, nil
+	return pipeline, nil
+}
+
+// GetBuildOutput downloads the output of an action from its OutputURL with http.DefaultClient
+//
+// Deprecated: use Client.StepLogs and Client.StepOutput, they use the HTTP client of the Client
+func GetBuildOutput(buildOutputURL string) ([]BuildOutput, error) {
+	return (&Client{}).buildOutput(buildOutputURL)
 }
 
 // func (c *Client)GetPipelineConfig(pipelineID string) (*PipelineConfig, error) {
@@ -301,8 +556,8 @@ func GetBuildOutput(buildOutputURL string) ([]BuildOutput, error) {
 // }
 
 type GetProjectPipelinesResponse struct {
-	Items         []Pipeline  `json:"items"`
-	NextPageToken interface{} `json:"next_page_token"`
+	Items         []Pipeline `json:"items"`
+	NextPageToken string     `json:"next_page_token"`
 }
 
 type Pipeline struct {
@@ -339,15 +594,71 @@ type GetWorkflowJobsResponse struct {
 }
 
 type Job struct {
-	Dependencies []string  `json:"dependencies"`
-	JobNumber    int       `json:"job_number"`
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	ProjectSlug  string    `json:"project_slug"`
-	Status       string    `json:"status"`
-	StopTime     time.Time `json:"stop_time"`
-	Type         string    `json:"type"`
-	StartTime    time.Time `json:"start_time"`
+	// ApprovalRequestID is only set for approval jobs, it is what ApproveJob approves
+	ApprovalRequestID string    `json:"approval_request_id"`
+	Dependencies      []string  `json:"dependencies"`
+	JobNumber         int       `json:"job_number"`
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	ProjectSlug       string    `json:"project_slug"`
+	Status            string    `json:"status"`
+	StopTime          time.Time `json:"stop_time"`
+	Type              string    `json:"type"`
+	StartTime         time.Time `json:"start_time"`
+}
+
+// JobDone reports whether job, one of the jobs of a workflow, won't change anymore
+// Besides the jobs that succeeded or failed that is the jobs that ended another way, like canceled or timed out ones,
+// and blocked jobs once none of the jobs they could be waiting on is still going, jobs after a failed one stay blocked
+func JobDone(job Job, jobs []Job) bool {
+	if job.Status != "blocked" {
+		return jobEnded(job.Status)
+	}
+
+	for _, j := range jobs {
+		if j.Status != "blocked" && !jobEnded(j.Status) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WorkflowDone reports whether every job of a workflow is done, a workflow that has no jobs yet isn't
+func WorkflowDone(jobs []Job) bool {
+	for _, job := range jobs {
+		if !JobDone(job, jobs) {
+			return false
+		}
+	}
+
+	return len(jobs) > 0
+}
+
+// jobEnded says whether a job status is one CircleCI jobs end with
+func jobEnded(status string) bool {
+	switch status {
+	case "success", "failed", "canceled", "not_run", "infrastructure_fail", "timedout", "unauthorized", "terminated-unknown", "retried":
+		return true
+	}
+
+	return false
+}
+
+type GetJobTestsResponse struct {
+	Items         []TestResult `json:"items"`
+	NextPageToken string       `json:"next_page_token"`
+}
+
+// TestResult is the result of one test of a job, Result is success, failure or skipped
+type TestResult struct {
+	Message   string  `json:"message"`
+	Source    string  `json:"source"`
+	RunTime   float64 `json:"run_time"`
+	File      string  `json:"file"`
+	Result    string  `json:"result"`
+	Name      string  `json:"name"`
+	Classname string  `json:"classname"`
 }
 
 type Workflow struct {
@@ -0,0 +1,38 @@
+package circleci
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDebugMessagesDontHaveTheToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "w1", "name": "build"}`))
+	}))
+	defer server.Close()
+
+	logs := &bytes.Buffer{}
+	base, _ := url.Parse(server.URL + "/api/v2/")
+	c := &Client{
+		BaseURL: base,
+		Token:   "secret-token",
+		Logger:  slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	_, err := c.GetWorkflow("w1")
+	if err != nil {
+		t.Fatalf("Expected the workflow, got %s", err)
+	}
+
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Errorf("Expected the token to be redacted, got %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "circle-token=REDACTED") {
+		t.Errorf("Expected the requests to be logged, got %s", logs.String())
+	}
+}
@@ -0,0 +1,105 @@
+package circleci
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// JobRef names a job of a project by its number
+type JobRef struct {
+	VCS     string
+	Account string
+	Repo    string
+	Number  int
+}
+
+// JobLogs are the steps a job ran, with where the output of each container is
+type JobLogs struct {
+	Parallelism int // how many containers the job ran on
+	Steps       []StepLog
+}
+
+// StepLog is a step of a job on one of its containers, StepOutput downloads its output
+type StepLog struct {
+	Step      string
+	Container int // the index of the container
+	Status    string
+	OutputURL string
+}
+
+// StepLogs returns the steps of a job, the v2 API doesn't have step output so they come from the v1.1 build
+// Code that reads step output goes through StepLogs so it doesn't depend on which API version serves it
+func (c *Client) StepLogs(job JobRef) (*JobLogs, error) {
+	build, err := c.GetBuild(job.VCS, job.Account, job.Repo, job.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	return stepLogs(build.Parallel, build.Steps), nil
+}
+
+func stepLogs(parallelism int, steps []*Step) *JobLogs {
+	logs := &JobLogs{Parallelism: parallelism}
+	for _, step := range steps {
+		for _, action := range step.Actions {
+			logs.Steps = append(logs.Steps, StepLog{Step: step.Name, Container: action.Index, Status: action.Status, OutputURL: action.OutputURL})
+		}
+	}
+
+	return logs
+}
+
+// StepOutput downloads the output of a step, a step without output has an empty one
+func (c *Client) StepOutput(log StepLog) (string, error) {
+	if log.OutputURL == "" {
+		return "", nil
+	}
+
+	messages, err := c.buildOutput(log.OutputURL)
+	if err != nil {
+		return "", err
+	}
+
+	output := ""
+	for _, m := range messages {
+		output = output + m.Message
+	}
+
+	return output, nil
+}
+
+// buildOutput downloads output, output URLs are signed so the token isn't sent and responses aren't cached
+func (c *Client) buildOutput(outputURL string) ([]BuildOutput, error) {
+	req, err := http.NewRequestWithContext(c.requestContext(), "GET", outputURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.debugRequest(req)
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	c.debugResponse(resp)
+
+	if resp.StatusCode >= 300 {
+		return nil, &APIError{HTTPStatusCode: resp.StatusCode, Message: "unable to download the step output"}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	output := []BuildOutput{}
+	err = json.Unmarshal(body, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
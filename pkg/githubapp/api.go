@@ -0,0 +1,104 @@
+package githubapp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+// ErrNotFound is returned by API methods when GitHub answers 404, like for a file the repo doesn't have
+var ErrNotFound = errors.New("Not Found")
+
+// API is what a GitHub App does on GitHub for one of its installations, Client implements it
+// Code that takes an API rather than a *github.Client can be tested with a fake GitHub
+type API interface {
+	// CreateComment comments on an issue or pull request
+	CreateComment(ctx context.Context, owner, repo string, number int, body string) (*github.IssueComment, error)
+	// GetComment gets a comment on an issue or pull request
+	GetComment(ctx context.Context, owner, repo string, id int64) (*github.IssueComment, error)
+	// EditComment replaces the body of a comment on an issue or pull request
+	EditComment(ctx context.Context, owner, repo string, id int64, body string) (*github.IssueComment, error)
+	// GetFile gets the content of the file at path on ref, the default branch when ref is empty
+	GetFile(ctx context.Context, owner, repo, path, ref string) ([]byte, error)
+	// GetPermissionLevel gets the permission login has on the repo, admin, write, read or none
+	GetPermissionLevel(ctx context.Context, owner, repo, login string) (string, error)
+	// GetPullRequest gets a pull request
+	GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, error)
+}
+
+// Installations hands out the API of each installation of a GitHub App, ClientFactory implements it
+type Installations interface {
+	Installation(installationID int64) (API, error)
+}
+
+// Client is the API of an installation with a go-github client
+type Client struct {
+	GitHub *github.Client
+}
+
+var _ API = (*Client)(nil)
+
+// notFound turns the error of a request GitHub answered with 404 into ErrNotFound
+func notFound(resp *github.Response, err error) error {
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// CreateComment comments on an issue or pull request
+func (c *Client) CreateComment(ctx context.Context, owner, repo string, number int, body string) (*github.IssueComment, error) {
+	comment, resp, err := c.GitHub.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{Body: &body})
+	return comment, notFound(resp, err)
+}
+
+// GetComment gets a comment on an issue or pull request
+func (c *Client) GetComment(ctx context.Context, owner, repo string, id int64) (*github.IssueComment, error) {
+	comment, resp, err := c.GitHub.Issues.GetComment(ctx, owner, repo, id)
+	return comment, notFound(resp, err)
+}
+
+// EditComment replaces the body of a comment on an issue or pull request
+func (c *Client) EditComment(ctx context.Context, owner, repo string, id int64, body string) (*github.IssueComment, error) {
+	comment, resp, err := c.GitHub.Issues.EditComment(ctx, owner, repo, id, &github.IssueComment{Body: &body})
+	return comment, notFound(resp, err)
+}
+
+// GetFile gets the content of the file at path on ref, ErrNotFound when there is no file at path
+func (c *Client) GetFile(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	file, _, resp, err := c.GitHub.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		return nil, notFound(resp, err)
+	}
+
+	// a directory has no file content
+	if file == nil {
+		return nil, ErrNotFound
+	}
+
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(content), nil
+}
+
+// GetPermissionLevel gets the permission login has on the repo, admin, write, read or none
+func (c *Client) GetPermissionLevel(ctx context.Context, owner, repo, login string) (string, error) {
+	permission, resp, err := c.GitHub.Repositories.GetPermissionLevel(ctx, owner, repo, login)
+	if err != nil {
+		return "", notFound(resp, err)
+	}
+
+	return permission.GetPermission(), nil
+}
+
+// GetPullRequest gets a pull request
+func (c *Client) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, error) {
+	pr, resp, err := c.GitHub.PullRequests.Get(ctx, owner, repo, number)
+	return pr, notFound(resp, err)
+}
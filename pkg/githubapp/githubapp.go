@@ -5,17 +5,35 @@ package githubapp
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/bradleyfalzon/ghinstallation"
 	"github.com/google/go-github/github"
 )
 
+// Validator verifies webhook requests are coming from GitHub
+type Validator struct {
+	// Secrets that are accepted when checking the signature, more than one is only needed while rotating the webhook secret
+	Secrets []string
+	// AllowSHA1 accepts the legacy X-Hub-Signature header when a request has no X-Hub-Signature-256 header
+	AllowSHA1 bool
+}
+
 // ValidateRequest verifies the request is a GitHub webhook event and verifies the request with a secret
+// Only X-Hub-Signature-256 is accepted, use a Validator to fall back to SHA-1 or accept more than one secret
 func ValidateRequest(r events.APIGatewayProxyRequest, webhookSecret string) error {
+	return Validator{Secrets: []string{webhookSecret}}.Validate(r)
+}
+
+// Validate verifies the request is a GitHub webhook event signed with one of the secrets
+func (v Validator) Validate(r events.APIGatewayProxyRequest) error {
 	if r.HTTPMethod != "POST" {
 		return fmt.Errorf("HTTPMethod is not POST, this server only accepts post requests on this endpoint")
 	}
@@ -26,29 +44,154 @@ func ValidateRequest(r events.APIGatewayProxyRequest, webhookSecret string) erro
 		return fmt.Errorf("X-GitHub-Event header is not present, this has to be present to be a valid github webhook event")
 	}
 
-	signature := r.Headers["X-Hub-Signature"]
+	if len(v.Secrets) == 0 {
+		return fmt.Errorf("no webhook secrets are configured, unable to verify the request")
+	}
+
+	// prefer sha256, GitHub sends both headers when it can
+	prefix, newHash := "sha256=", sha256.New
+	signature := r.Headers["X-Hub-Signature-256"]
 	if signature == "" {
-		return fmt.Errorf("X-Hub-Signature header is not present, this has to be present to sign the request from GitHub")
+		if !v.AllowSHA1 {
+			return fmt.Errorf("X-Hub-Signature-256 header is not present, this has to be present to sign the request from GitHub")
+		}
+
+		prefix, newHash = "sha1=", sha1.New
+		signature = r.Headers["X-Hub-Signature"]
+		if signature == "" {
+			return fmt.Errorf("X-Hub-Signature-256 and X-Hub-Signature headers are not present, one has to be present to sign the request from GitHub")
+		}
+	}
+
+	if !strings.HasPrefix(signature, prefix) {
+		return fmt.Errorf("signature header is not in the expected %s<hex digest> format", prefix)
+	}
+
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil || len(sum) != newHash().Size() {
+		return fmt.Errorf("signature header is not in the expected %s<hex digest> format", prefix)
+	}
+
+	for _, secret := range v.Secrets {
+		if secret != "" && hmac.Equal(sum, signPayload(newHash, secret, []byte(r.Body))) {
+			return nil
+		}
 	}
 
-	payload := []byte(r.Body)
-	mac := hmac.New(sha1.New, []byte(webhookSecret))
+	return fmt.Errorf("HMAC verification failed, this request might not be coming from GitHub")
+}
+
+func signPayload(newHash func() hash.Hash, secret string, payload []byte) []byte {
+	mac := hmac.New(newHash, []byte(secret))
 	_, _ = mac.Write(payload)
-	expectedMAC := hex.EncodeToString(mac.Sum(nil))
+	return mac.Sum(nil)
+}
+
+// DefaultHost is the host name of github.com webhooks, GitHub Enterprise Server webhooks name their host in a header
+const DefaultHost = "github.com"
 
-	if !hmac.Equal([]byte(signature[5:]), []byte(expectedMAC)) {
-		return fmt.Errorf("HMAC verification failed, this request might not be coming from GitHub")
+// WebhookHost returns the host name of the GitHub instance that sent the webhook
+func WebhookHost(r events.APIGatewayProxyRequest) string {
+	if host := r.Headers["X-GitHub-Enterprise-Host"]; host != "" {
+		return host
 	}
-	return nil
+
+	return DefaultHost
 }
 
 // NewGithubClient returns an authenticated GithubClient from the go-github package
-// This is to be used for github app authorization
-func NewGithubClient(integrationID, installationID int, privateKey []byte) (*github.Client, error) {
-	itr, err := ghinstallation.New(http.DefaultTransport, integrationID, installationID, privateKey)
+// This is to be used for github app authorization, appID is the ID of the GitHub App and
+// installationID is the ID of the app's installation on the account that owns the repo
+// Every client fetches its own installation token, use a ClientFactory to reuse tokens
+func NewGithubClient(appID, installationID int, privateKey []byte) (*github.Client, error) {
+	return NewEnterpriseGithubClient("", "", appID, installationID, privateKey)
+}
+
+// NewEnterpriseGithubClient is NewGithubClient for a GitHub Enterprise Server instance
+// baseURL is the API URL, usually https://<host>/api/v3/, and uploadURL is usually https://<host>/api/uploads/
+// An empty baseURL targets github.com
+func NewEnterpriseGithubClient(baseURL, uploadURL string, appID, installationID int, privateKey []byte) (*github.Client, error) {
+	return newClient(http.DefaultTransport, baseURL, uploadURL, appID, installationID, privateKey)
+}
+
+// newClient is NewEnterpriseGithubClient sending its requests, the installation token requests too, through transport
+func newClient(transport http.RoundTripper, baseURL, uploadURL string, appID, installationID int, privateKey []byte) (*github.Client, error) {
+	itr, err := ghinstallation.New(transport, appID, installationID, privateKey)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating github client, error: %s", err)
 	}
 
-	return github.NewClient(&http.Client{Transport: itr}), nil
+	if baseURL == "" {
+		return github.NewClient(&http.Client{Transport: itr}), nil
+	}
+
+	// ghinstallation wants the api url without a trailing slash
+	itr.BaseURL = strings.TrimSuffix(baseURL, "/")
+
+	if uploadURL == "" {
+		uploadURL = baseURL
+	}
+
+	client, err := github.NewEnterpriseClient(baseURL, uploadURL, &http.Client{Transport: itr})
+	if err != nil {
+		return nil, fmt.Errorf("Error creating github enterprise client, error: %s", err)
+	}
+
+	return client, nil
+}
+
+// ClientFactory hands out GitHub clients for every installation of a GitHub App
+// Clients are cached by installation ID, each client keeps its installation token and only
+// fetches a new one when it is about to expire, so a warm lambda doesn't request a token per call
+type ClientFactory struct {
+	AppID      int
+	PrivateKey []byte
+	BaseURL    string            // GitHub Enterprise Server API URL, empty for github.com
+	UploadURL  string            // GitHub Enterprise Server upload URL, defaults to BaseURL
+	Transport  http.RoundTripper // sends the requests of every client, defaults to http.DefaultTransport
+
+	mu      sync.Mutex
+	clients map[int64]*github.Client
+}
+
+// NewClientFactory returns a ClientFactory for the GitHub App with appID
+func NewClientFactory(appID int, privateKey []byte) *ClientFactory {
+	return &ClientFactory{AppID: appID, PrivateKey: privateKey}
+}
+
+// Client returns the client for installationID, creating it the first time the installation is seen
+func (f *ClientFactory) Client(installationID int64) (*github.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if client, ok := f.clients[installationID]; ok {
+		return client, nil
+	}
+
+	transport := f.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	client, err := newClient(transport, f.BaseURL, f.UploadURL, f.AppID, int(installationID), f.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.clients == nil {
+		f.clients = make(map[int64]*github.Client)
+	}
+	f.clients[installationID] = client
+
+	return client, nil
+}
+
+// Installation returns the API of installationID, with the client Client returns for it
+func (f *ClientFactory) Installation(installationID int64) (API, error) {
+	client, err := f.Client(installationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{GitHub: client}, nil
 }